@@ -3,9 +3,11 @@ package senpai
 import (
 	"crypto/tls"
 	"fmt"
+	"io"
 	"net"
 	"os"
 	"os/exec"
+	"sort"
 	"strings"
 	"time"
 	"unicode"
@@ -13,10 +15,25 @@ import (
 	"git.sr.ht/~taiite/senpai/irc"
 	"git.sr.ht/~taiite/senpai/ui"
 	"github.com/gdamore/tcell/v2"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
 )
 
 const eventChanSize = 64
 
+// identifyTimeout is how long to wait for NickServ identification before
+// joining channels anyway.
+const identifyTimeout = 10 * time.Second
+
+// typingIdleEvent is sent when the user might have stopped typing.
+type typingIdleEvent struct{}
+
+// identifyTimeoutEvent is sent when identifyTimeout has passed after s sent
+// its NickServ identification.
+type identifyTimeoutEvent struct {
+	s *irc.Session
+}
+
 type source int
 
 const (
@@ -65,27 +82,47 @@ func (b *bound) Update(line *ui.Line) {
 
 type event struct {
 	src     source
+	netID   string // the network of IRC events.
 	content interface{}
 }
 
+// boundKey identifies a buffer across networks.
+type boundKey struct {
+	netID  string
+	target string
+}
+
 type App struct {
-	win     *ui.UI
-	s       *irc.Session
-	pasting bool
-	events  chan event
+	win      *ui.UI
+	sessions map[string]*irc.Session  // connected sessions, by network ID.
+	networks map[string]chan struct{} // bouncer networks, closed on deletion.
+	pasting  bool
+	events   chan event
+
+	typingAt    time.Time   // when the last typing notification was sent.
+	typingTimer *time.Timer // sends a typingIdleEvent after the typing-idle delay.
 
 	cfg        Config
 	highlights []string
 
-	lastQuery     string
-	messageBounds map[string]bound
+	lastQuery      string
+	lastQueryNetID string
+	messageBounds  map[boundKey]bound
+	channelKeys    map[boundKey]string     // keys of joined channels, by casemapped name.
+	joined         map[boundKey]struct{}   // channels to join again on reconnection.
+	identifying    map[string]*irc.Session // sessions waiting for NickServ identification to join channels.
 }
 
 func NewApp(cfg Config) (app *App, err error) {
 	app = &App{
 		cfg:           cfg,
+		sessions:      map[string]*irc.Session{},
+		networks:      map[string]chan struct{}{},
 		events:        make(chan event, eventChanSize),
-		messageBounds: map[string]bound{},
+		messageBounds: map[boundKey]bound{},
+		channelKeys:   map[boundKey]string{},
+		joined:        map[boundKey]struct{}{},
+		identifying:   map[string]*irc.Session{},
 	}
 
 	if cfg.Highlights != nil {
@@ -107,6 +144,9 @@ func NewApp(cfg Config) (app *App, err error) {
 		AutoComplete: func(cursorIdx int, text []rune) []ui.Completion {
 			return app.completions(cursorIdx, text)
 		},
+		MarkRead: func(netID, buffer string, at time.Time) {
+			app.markRead(netID, buffer, at)
+		},
 		Mouse: mouse,
 	})
 	if err != nil {
@@ -125,17 +165,24 @@ func NewApp(cfg Config) (app *App, err error) {
 
 func (app *App) Close() {
 	app.win.Close()
-	if app.s != nil {
-		app.s.Close()
+	for _, s := range app.sessions {
+		s.Close()
 	}
 }
 
 func (app *App) Run() {
 	go app.uiLoop()
-	go app.ircLoop()
+	go app.ircLoop("", nil)
 	app.eventLoop()
 }
 
+// session returns the session of the network of the current buffer, or nil if
+// it is not connected.
+func (app *App) session() *irc.Session {
+	netID, _ := app.win.CurrentBuffer()
+	return app.sessions[netID]
+}
+
 // eventLoop retrieves events (in batches) from the event channel and handle
 // them, then draws the interface after each batch is handled.
 func (app *App) eventLoop() {
@@ -159,8 +206,9 @@ func (app *App) eventLoop() {
 			app.setStatus()
 			app.updatePrompt()
 			var currentMembers []irc.Member
-			if app.s != nil {
-				currentMembers = app.s.Names(app.win.CurrentBuffer())
+			if s := app.session(); s != nil {
+				_, buffer := app.win.CurrentBuffer()
+				currentMembers = s.Names(buffer)
 			}
 			app.win.Draw(currentMembers)
 		}
@@ -168,8 +216,10 @@ func (app *App) eventLoop() {
 }
 
 // ircLoop maintains a connection to the IRC server by connecting and then
-// forwarding IRC events to app.events repeatedly.
-func (app *App) ircLoop() {
+// forwarding IRC events to app.events repeatedly.  netID is the bouncer network
+// the connection is bound to, or "" for the main connection.  The loop stops
+// when stop is closed.
+func (app *App) ircLoop(netID string, stop <-chan struct{}) {
 	var auth irc.SASLClient
 	if app.cfg.Password != nil {
 		auth = &irc.SASLPlain{
@@ -182,29 +232,43 @@ func (app *App) ircLoop() {
 		Username: app.cfg.User,
 		RealName: app.cfg.Real,
 		Auth:     auth,
+		NetID:    netID,
+	}
+	params.DisabledCaps = app.cfg.DisableCaps
+	if app.cfg.Password != nil && app.cfg.PasswordFallback == fallbackPass {
+		params.Pass = *app.cfg.Password
+	}
+	var fallback encoding.Encoding
+	if app.cfg.Encoding != "" {
+		fallback, _ = htmlindex.Get(app.cfg.Encoding) // checked by ParseConfig
 	}
 	for !app.win.ShouldExit() {
-		conn := app.connect()
-		in, out := irc.ChanInOut(conn)
+		conn := app.connect(netID, stop)
+		if conn == nil {
+			return
+		}
+		in, out := irc.ChanInOut(conn, fallback)
 		if app.cfg.Debug {
-			out = app.debugOutputMessages(out)
+			out = app.debugOutputMessages(netID, out)
 		}
 		session := irc.NewSession(out, params)
 		app.events <- event{
 			src:     ircEvent,
+			netID:   netID,
 			content: session,
 		}
 		go func() {
 			for stop := range session.TypingStops() {
 				app.events <- event{
 					src:     ircEvent,
+					netID:   netID,
 					content: stop,
 				}
 			}
 		}()
 		for msg := range in {
 			if app.cfg.Debug {
-				app.queueStatusLine(ui.Line{
+				app.queueStatusLine(netID, ui.Line{
 					At:   time.Now(),
 					Head: "IN --",
 					Body: ui.PlainString(msg.String()),
@@ -212,43 +276,91 @@ func (app *App) ircLoop() {
 			}
 			app.events <- event{
 				src:     ircEvent,
+				netID:   netID,
 				content: msg,
 			}
 		}
 		app.events <- event{
 			src:     ircEvent,
+			netID:   netID,
 			content: nil,
 		}
-		app.queueStatusLine(ui.Line{
+		if isStopped(stop) {
+			return
+		}
+		app.queueStatusLine(netID, ui.Line{
 			Head:      "!!",
 			HeadColor: tcell.ColorRed,
 			Body:      ui.PlainString("Connection lost"),
 		})
-		time.Sleep(10 * time.Second)
+		if sleep(10*time.Second, stop) {
+			return
+		}
 	}
 }
 
-func (app *App) connect() net.Conn {
+// isStopped reports whether stop has been closed.
+func isStopped(stop <-chan struct{}) bool {
+	select {
+	case <-stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// sleep waits for the given duration, and reports whether stop has been closed
+// in the meantime.
+func sleep(d time.Duration, stop <-chan struct{}) (stopped bool) {
+	select {
+	case <-time.After(d):
+		return false
+	case <-stop:
+		return true
+	}
+}
+
+// connect tries to connect to the server until it succeeds, or returns nil if
+// stop is closed.
+func (app *App) connect(netID string, stop <-chan struct{}) io.ReadWriteCloser {
 	for {
-		app.queueStatusLine(ui.Line{
+		target := app.cfg.Addr
+		if app.cfg.ConnectCommand != "" {
+			target = app.cfg.ConnectCommand
+		}
+		app.queueStatusLine(netID, ui.Line{
 			Head: "--",
-			Body: ui.PlainSprintf("Connecting to %s...", app.cfg.Addr),
+			Body: ui.PlainSprintf("Connecting to %s...", target),
 		})
-		conn, err := app.tryConnect()
+		conn, err := app.tryConnect(netID)
 		if err == nil {
+			if isStopped(stop) {
+				conn.Close()
+				return nil
+			}
 			return conn
 		}
-		app.queueStatusLine(ui.Line{
+		app.queueStatusLine(netID, ui.Line{
 			Head:      "!!",
 			HeadColor: tcell.ColorRed,
 			Body:      ui.PlainSprintf("Connection failed: %v", err),
 		})
-		time.Sleep(1 * time.Minute)
+		if sleep(1*time.Minute, stop) {
+			return nil
+		}
 	}
 }
 
-func (app *App) tryConnect() (conn net.Conn, err error) {
+func (app *App) tryConnect(netID string) (conn io.ReadWriteCloser, err error) {
+	if app.cfg.ConnectCommand != "" {
+		return startCommand(app.cfg.ConnectCommand)
+	}
+
 	addr := app.cfg.Addr
+	if strings.HasPrefix(addr, "unix://") {
+		return net.Dial("unix", strings.TrimPrefix(addr, "unix://"))
+	}
+
 	colonIdx := strings.LastIndexByte(addr, ':')
 	bracketIdx := strings.LastIndexByte(addr, ']')
 	if colonIdx <= bracketIdx {
@@ -261,32 +373,42 @@ func (app *App) tryConnect() (conn net.Conn, err error) {
 		}
 	}
 
-	conn, err = net.Dial("tcp", addr)
+	proxy, err := app.proxyURL(netID, addr)
+	if err != nil {
+		return
+	}
+	var netConn net.Conn
+	if proxy != nil {
+		netConn, err = dialProxy(proxy, addr)
+	} else {
+		netConn, err = net.Dial("tcp", addr)
+	}
 	if err != nil {
 		return
 	}
 
 	if !app.cfg.NoTLS {
 		host, _, _ := net.SplitHostPort(addr) // should succeed since net.Dial did.
-		conn = tls.Client(conn, &tls.Config{
+		tlsConn := tls.Client(netConn, &tls.Config{
 			ServerName: host,
 			NextProtos: []string{"irc"},
 		})
-		err = conn.(*tls.Conn).Handshake()
+		err = tlsConn.Handshake()
 		if err != nil {
-			conn.Close()
+			tlsConn.Close()
 			return nil, err
 		}
+		netConn = tlsConn
 	}
 
-	return
+	return netConn, nil
 }
 
-func (app *App) debugOutputMessages(out chan<- irc.Message) chan<- irc.Message {
+func (app *App) debugOutputMessages(netID string, out chan<- irc.Message) chan<- irc.Message {
 	debugOut := make(chan irc.Message, cap(out))
 	go func() {
 		for msg := range debugOut {
-			app.queueStatusLine(ui.Line{
+			app.queueStatusLine(netID, ui.Line{
 				At:   time.Now(),
 				Head: "OUT --",
 				Body: ui.PlainString(msg.String()),
@@ -319,7 +441,7 @@ func (app *App) handleEvents(evs []event) {
 		case uiEvent:
 			app.handleUIEvent(ev.content)
 		case ircEvent:
-			app.handleIRCEvent(ev.content)
+			app.handleIRCEvent(ev.netID, ev.content)
 		default:
 			panic("unreachable")
 		}
@@ -336,8 +458,10 @@ func (app *App) handleUIEvent(ev interface{}) {
 		app.handleMouseEvent(ev)
 	case *tcell.EventKey:
 		app.handleKeyEvent(ev)
-	case ui.Line:
-		app.addStatusLine(ev)
+	case statusLine:
+		app.addStatusLine(ev.netID, ev.line)
+	case typingIdleEvent:
+		app.pauseTyping()
 	default:
 		return
 	}
@@ -462,11 +586,18 @@ func (app *App) handleKeyEvent(ev *tcell.EventKey) {
 			app.typing()
 		}
 	case tcell.KeyCR, tcell.KeyLF:
-		buffer := app.win.CurrentBuffer()
+		if app.pasting {
+			// Keep the pasted lines together, so that they can be
+			// sent as one multiline message.
+			app.win.InputRune('\n')
+			app.typing()
+			break
+		}
+		netID, buffer := app.win.CurrentBuffer()
 		input := app.win.InputEnter()
 		err := app.handleInput(buffer, input)
 		if err != nil {
-			app.win.AddLine(app.win.CurrentBuffer(), ui.NotifyUnread, ui.Line{
+			app.win.AddLine(netID, buffer, ui.NotifyUnread, ui.Line{
 				At:        time.Now(),
 				Head:      "!!",
 				HeadColor: tcell.ColorRed,
@@ -484,29 +615,57 @@ func (app *App) handleKeyEvent(ev *tcell.EventKey) {
 // requestHistory is a wrapper around irc.Session.RequestHistory to only request
 // history when needed.
 func (app *App) requestHistory() {
-	if app.s == nil {
+	s := app.session()
+	if s == nil {
 		return
 	}
-	buffer := app.win.CurrentBuffer()
-	if app.win.IsAtTop() && buffer != Home {
+	netID, buffer := app.win.CurrentBuffer()
+	if app.win.IsAtTop() && !isHome(buffer) {
 		t := time.Now()
-		if bound, ok := app.messageBounds[buffer]; ok {
+		if bound, ok := app.messageBounds[boundKey{netID, buffer}]; ok {
 			t = bound.first
 		}
-		app.s.NewHistoryRequest(buffer).
+		s.NewHistoryRequest(buffer).
 			WithLimit(100).
 			Before(t)
 	}
 }
 
-func (app *App) handleIRCEvent(ev interface{}) {
+func (app *App) handleIRCEvent(netID string, ev interface{}) {
 	if ev == nil {
-		app.s.Close()
-		app.s = nil
+		if s, ok := app.sessions[netID]; ok {
+			s.Close()
+			delete(app.sessions, netID)
+		}
+		delete(app.identifying, netID)
+		for k := range app.joined {
+			if k.netID == netID {
+				app.win.SetInactive(netID, k.target, true)
+			}
+		}
 		return
 	}
 	if s, ok := ev.(*irc.Session); ok {
-		app.s = s
+		if _, ok := app.networks[netID]; netID != "" && !ok {
+			// The network has been deleted while connecting.
+			s.Close()
+			return
+		}
+		app.sessions[netID] = s
+		return
+	}
+	if ev, ok := ev.(identifyTimeoutEvent); ok {
+		if s, ok := app.identifying[netID]; ok && s == ev.s {
+			delete(app.identifying, netID)
+			app.addStatusLine(netID, ui.Line{
+				At:        time.Now(),
+				Head:      "!!",
+				HeadColor: tcell.ColorRed,
+				Body:      ui.PlainString("NickServ identification timed out, joining channels anyway"),
+			})
+			app.autojoin(netID, s)
+			app.backfillQueries(netID, s)
+		}
 		return
 	}
 	if _, ok := ev.(irc.Typing); ok {
@@ -515,39 +674,78 @@ func (app *App) handleIRCEvent(ev interface{}) {
 	}
 
 	msg := ev.(irc.Message)
+	s, ok := app.sessions[netID]
+	if !ok {
+		return
+	}
 
 	// Mutate IRC state
-	ev = app.s.HandleMessage(msg)
+	ev = s.HandleMessage(msg)
 
 	// Mutate UI state
 	switch ev := ev.(type) {
 	case irc.RegisteredEvent:
-		for _, channel := range app.cfg.Channels {
-			// TODO: group JOIN messages
-			// TODO: support autojoining channels with keys
-			app.s.Join(channel, "")
+		if netID == "" && s.HasCapability("soju.im/bouncer-networks") {
+			// Channels are joined on the networks, not on the
+			// bouncer itself.
+			s.ListNetworks()
+		} else if app.cfg.Password != nil && app.cfg.PasswordFallback == fallbackNickServ && !s.LoggedIn() {
+			// Channels might require being identified, join them
+			// once identified.
+			app.identify(netID, s)
+		} else {
+			app.autojoin(netID, s)
+			app.backfillQueries(netID, s)
 		}
 		var body ui.StyledStringBuilder
 		body.WriteString("Connected to the server")
-		if app.s.Nick() != app.cfg.Nick {
+		if s.Nick() != app.cfg.Nick {
 			body.WriteString(" as ")
-			body.WriteString(app.s.Nick())
+			body.WriteString(s.Nick())
 		}
-		app.win.AddLine(Home, ui.NotifyUnread, ui.Line{
+		app.win.AddLine(netID, homeBuffer(netID), ui.NotifyUnread, ui.Line{
 			At:   msg.TimeOrNow(),
 			Head: "--",
 			Body: body.StyledString(),
 		})
+		if app.cfg.Password != nil && app.cfg.PasswordFallback == "" && !s.LoggedIn() {
+			app.win.AddLine(netID, homeBuffer(netID), ui.NotifyUnread, ui.Line{
+				At:        msg.TimeOrNow(),
+				Head:      "!!",
+				HeadColor: tcell.ColorRed,
+				Body:      ui.PlainString("Not logged in: SASL authentication is unavailable or failed"),
+			})
+		}
+	case irc.LoginEvent:
+		line := ui.Line{
+			At:   msg.TimeOrNow(),
+			Head: "--",
+		}
+		if ev.Failed {
+			line.Head = "!!"
+			line.HeadColor = tcell.ColorRed
+			line.Body = ui.PlainSprintf("Authentication failed: %s", ev.Message)
+		} else if ev.Account != "" {
+			line.Body = ui.PlainSprintf("Logged in as %s", ev.Account)
+			if app.identifying[netID] == s {
+				delete(app.identifying, netID)
+				app.autojoin(netID, s)
+				app.backfillQueries(netID, s)
+			}
+		} else {
+			line.Body = ui.PlainSprintf("Logged out: %s", ev.Message)
+		}
+		app.addStatusLine(netID, line)
 	case irc.SelfNickEvent:
 		var body ui.StyledStringBuilder
-		body.Grow(len(ev.FormerNick) + 4 + len(app.s.Nick()))
+		body.Grow(len(ev.FormerNick) + 4 + len(s.Nick()))
 		body.SetStyle(tcell.StyleDefault.Foreground(tcell.ColorGray))
 		body.WriteString(ev.FormerNick)
 		body.SetStyle(tcell.StyleDefault)
 		body.WriteRune('\u2192') // right arrow
 		body.SetStyle(tcell.StyleDefault.Foreground(tcell.ColorGray))
-		body.WriteString(app.s.Nick())
-		app.addStatusLine(ui.Line{
+		body.WriteString(s.Nick())
+		app.addStatusLine(netID, ui.Line{
 			At:        msg.TimeOrNow(),
 			Head:      "--",
 			HeadColor: tcell.ColorGray,
@@ -563,8 +761,8 @@ func (app *App) handleIRCEvent(ev interface{}) {
 		body.WriteRune('\u2192') // right arrow
 		body.SetStyle(tcell.StyleDefault.Foreground(tcell.ColorGray))
 		body.WriteString(ev.User)
-		for _, c := range app.s.ChannelsSharedWith(ev.User) {
-			app.win.AddLine(c, ui.NotifyNone, ui.Line{
+		for _, c := range s.ChannelsSharedWith(ev.User) {
+			app.win.AddLine(netID, c, ui.NotifyNone, ui.Line{
 				At:        msg.TimeOrNow(),
 				Head:      "--",
 				HeadColor: tcell.ColorGray,
@@ -573,14 +771,16 @@ func (app *App) handleIRCEvent(ev interface{}) {
 			})
 		}
 	case irc.SelfJoinEvent:
-		i, added := app.win.AddBuffer(ev.Channel)
-		bounds, ok := app.messageBounds[ev.Channel]
+		i, added := app.win.AddBuffer(netID, "", ev.Channel)
+		app.win.SetInactive(netID, ev.Channel, false)
+		app.joined[boundKey{netID, ev.Channel}] = struct{}{}
+		bounds, ok := app.messageBounds[boundKey{netID, ev.Channel}]
 		if added || !ok {
-			app.s.NewHistoryRequest(ev.Channel).
+			s.NewHistoryRequest(ev.Channel).
 				WithLimit(200).
 				Before(msg.TimeOrNow())
 		} else {
-			app.s.NewHistoryRequest(ev.Channel).
+			s.NewHistoryRequest(ev.Channel).
 				WithLimit(200).
 				After(bounds.last)
 		}
@@ -588,7 +788,7 @@ func (app *App) handleIRCEvent(ev interface{}) {
 			app.win.JumpBufferIndex(i)
 		}
 		if ev.Topic != "" {
-			app.printTopic(ev.Channel)
+			app.printTopic(netID, ev.Channel)
 		}
 	case irc.UserJoinEvent:
 		var body ui.StyledStringBuilder
@@ -597,7 +797,7 @@ func (app *App) handleIRCEvent(ev interface{}) {
 		body.WriteByte('+')
 		body.SetStyle(tcell.StyleDefault.Foreground(tcell.ColorGray))
 		body.WriteString(ev.User)
-		app.win.AddLine(ev.Channel, ui.NotifyNone, ui.Line{
+		app.win.AddLine(netID, ev.Channel, ui.NotifyNone, ui.Line{
 			At:        msg.TimeOrNow(),
 			Head:      "--",
 			HeadColor: tcell.ColorGray,
@@ -605,37 +805,43 @@ func (app *App) handleIRCEvent(ev interface{}) {
 			Mergeable: true,
 		})
 	case irc.SelfPartEvent:
-		app.win.RemoveBuffer(ev.Channel)
-		delete(app.messageBounds, ev.Channel)
+		app.closeBuffer(netID, ev.Channel)
 	case irc.UserPartEvent:
+		app.win.AddLine(netID, ev.Channel, ui.NotifyNone, app.formatLeave(msg.TimeOrNow(), ev.Prefix, ev.Reason))
+	case irc.KickEvent:
 		var body ui.StyledStringBuilder
-		body.Grow(len(ev.User) + 1)
 		body.SetStyle(tcell.StyleDefault.Foreground(tcell.ColorRed))
 		body.WriteByte('-')
 		body.SetStyle(tcell.StyleDefault.Foreground(tcell.ColorGray))
-		body.WriteString(ev.User)
-		app.win.AddLine(ev.Channel, ui.NotifyNone, ui.Line{
+		body.WriteString(ev.Kicked)
+		body.WriteString(" was kicked by ")
+		body.WriteString(ev.Kicker)
+		if ev.Reason != "" {
+			body.WriteString(": ")
+			body.WriteStyledString(ui.IRCString(ev.Reason))
+		}
+		notify := ui.NotifyUnread
+		if s.IsMe(ev.Kicked) {
+			// Keep the buffer open, so that the user sees why they
+			// have been kicked.
+			notify = ui.NotifyHighlight
+			app.win.SetInactive(netID, ev.Channel, true)
+			delete(app.joined, boundKey{netID, ev.Channel})
+			if app.cfg.AutoRejoinOnKick {
+				s.Join(ev.Channel, app.channelKeys[boundKey{netID, s.Casemap(ev.Channel)}])
+			}
+		}
+		app.win.AddLine(netID, ev.Channel, notify, ui.Line{
 			At:        msg.TimeOrNow(),
 			Head:      "--",
-			HeadColor: tcell.ColorGray,
+			HeadColor: tcell.ColorRed,
 			Body:      body.StyledString(),
-			Mergeable: true,
+			Highlight: notify == ui.NotifyHighlight,
 		})
 	case irc.UserQuitEvent:
-		var body ui.StyledStringBuilder
-		body.Grow(len(ev.User) + 1)
-		body.SetStyle(tcell.StyleDefault.Foreground(tcell.ColorRed))
-		body.WriteByte('-')
-		body.SetStyle(tcell.StyleDefault.Foreground(tcell.ColorGray))
-		body.WriteString(ev.User)
+		line := app.formatLeave(msg.TimeOrNow(), ev.Prefix, ev.Reason)
 		for _, c := range ev.Channels {
-			app.win.AddLine(c, ui.NotifyNone, ui.Line{
-				At:        msg.TimeOrNow(),
-				Head:      "--",
-				HeadColor: tcell.ColorGray,
-				Body:      body.StyledString(),
-				Mergeable: true,
-			})
+			app.win.AddLine(netID, c, ui.NotifyNone, line)
 		}
 	case irc.TopicChangeEvent:
 		var body ui.StyledStringBuilder
@@ -644,7 +850,7 @@ func (app *App) handleIRCEvent(ev interface{}) {
 		body.WriteString("Topic changed to: ")
 		topic := ui.IRCString(ev.Topic)
 		body.WriteString(topic.String())
-		app.win.AddLine(ev.Channel, ui.NotifyUnread, ui.Line{
+		app.win.AddLine(netID, ev.Channel, ui.NotifyUnread, ui.Line{
 			At:        msg.TimeOrNow(),
 			Head:      "--",
 			HeadColor: tcell.ColorGray,
@@ -656,38 +862,64 @@ func (app *App) handleIRCEvent(ev interface{}) {
 		body.SetStyle(tcell.StyleDefault.Foreground(tcell.ColorGray))
 		body.WriteString("Mode change: ")
 		body.WriteString(ev.Mode)
-		app.win.AddLine(ev.Channel, ui.NotifyUnread, ui.Line{
+		app.win.AddLine(netID, ev.Channel, ui.NotifyUnread, ui.Line{
 			At:        msg.TimeOrNow(),
 			Head:      "--",
 			HeadColor: tcell.ColorGray,
 			Body:      body.StyledString(),
 		})
 	case irc.MessageEvent:
-		buffer, line, hlNotification := app.formatMessage(ev)
+		buffer, line, hlNotification := app.formatMessage(s, netID, ev)
 		var notify ui.NotifyType
 		if hlNotification {
 			notify = ui.NotifyHighlight
 		} else {
 			notify = ui.NotifyUnread
 		}
-		app.win.AddLine(buffer, notify, line)
+		switch app.botPolicy(s, ev) {
+		case botsHide:
+			notify = ui.NotifyNone
+			hlNotification = false
+		case botsCollapse:
+			notify = ui.NotifyNone
+			hlNotification = false
+			app.win.AddLine(netID, buffer, notify, collapseLine(line))
+		default:
+			app.win.AddLine(netID, buffer, notify, line)
+		}
 		if hlNotification {
-			app.notifyHighlight(buffer, ev.User, line.Body.String())
+			app.notifyHighlight(netID, buffer, ev.User, line.Body.String())
 		}
-		if !app.s.IsChannel(msg.Params[0]) && !app.s.IsMe(ev.User) {
-			app.lastQuery = msg.Prefix.Name
+		if !ev.TargetIsChannel && !s.IsMe(ev.User) {
+			app.lastQuery = ev.User
+			app.lastQueryNetID = netID
 		}
-		bounds := app.messageBounds[ev.Target]
+		key := messageBoundKey(s, netID, ev)
+		bounds := app.messageBounds[key]
 		bounds.Update(&line)
-		app.messageBounds[ev.Target] = bounds
+		app.messageBounds[key] = bounds
 	case irc.HistoryEvent:
 		var linesBefore []ui.Line
 		var linesAfter []ui.Line
-		bounds, hasBounds := app.messageBounds[ev.Target]
+		var hidden []ui.Line
+		// Queries are shown in the home buffer, and their bounds are
+		// kept by nickname.
+		buffer, key := ev.Target, boundKey{netID, ev.Target}
+		if !s.IsChannel(ev.Target) {
+			buffer, key = homeBuffer(netID), boundKey{netID, s.Casemap(ev.Target)}
+		}
+		bounds, hasBounds := app.messageBounds[key]
 		for _, m := range ev.Messages {
 			switch ev := m.(type) {
 			case irc.MessageEvent:
-				_, line, _ := app.formatMessage(ev)
+				_, line, _ := app.formatMessage(s, netID, ev)
+				switch app.botPolicy(s, ev) {
+				case botsHide:
+					hidden = append(hidden, line)
+					continue
+				case botsCollapse:
+					line = collapseLine(line)
+				}
 				if hasBounds {
 					c := bounds.Compare(&line)
 					if c < 0 {
@@ -700,7 +932,7 @@ func (app *App) handleIRCEvent(ev interface{}) {
 				}
 			}
 		}
-		app.win.AddLines(ev.Target, linesBefore, linesAfter)
+		app.win.AddLines(netID, buffer, linesBefore, linesAfter)
 		if len(linesBefore) != 0 {
 			bounds.Update(&linesBefore[0])
 			bounds.Update(&linesBefore[len(linesBefore)-1])
@@ -709,7 +941,86 @@ func (app *App) handleIRCEvent(ev interface{}) {
 			bounds.Update(&linesAfter[0])
 			bounds.Update(&linesAfter[len(linesAfter)-1])
 		}
-		app.messageBounds[ev.Target] = bounds
+		for i := range hidden {
+			// So that hidden messages are not requested again.
+			bounds.Update(&hidden[i])
+		}
+		app.messageBounds[key] = bounds
+	case irc.ChannelListEvent:
+		app.printChannelList(netID, ev)
+	case irc.ServerNoticeEvent:
+		if app.isServerNoticeHidden(ev.Category) {
+			break
+		}
+		app.win.AddBuffer(netID, "", serverBuffer)
+		var body ui.StyledStringBuilder
+		if ev.Category != "" {
+			body.SetStyle(tcell.StyleDefault.Foreground(tcell.ColorGray))
+			body.WriteString(ev.Category)
+			body.WriteString(": ")
+			body.SetStyle(tcell.StyleDefault)
+		}
+		body.WriteStyledString(ui.IRCString(ev.Content))
+		app.win.AddLine(netID, serverBuffer, ui.NotifyUnread, ui.Line{
+			At:        ev.Time,
+			Head:      "*",
+			HeadColor: identColor(ev.Server),
+			Body:      body.StyledString(),
+		})
+	case irc.CapabilityEvent:
+		var body string
+		switch {
+		case ev.Rejected && ev.Enabled:
+			body = fmt.Sprintf("The server refused to change capability %s, it is still enabled", ev.Name)
+		case ev.Rejected:
+			body = fmt.Sprintf("The server refused to change capability %s, it is still disabled", ev.Name)
+		case ev.Enabled:
+			body = fmt.Sprintf("Capability %s enabled", ev.Name)
+		default:
+			body = fmt.Sprintf("Capability %s disabled", ev.Name)
+		}
+		app.addStatusLine(netID, ui.Line{
+			At:        msg.TimeOrNow(),
+			Head:      "--",
+			HeadColor: tcell.ColorGray,
+			Body:      ui.PlainString(body),
+		})
+	case irc.UserModeEvent:
+		var body string
+		if ev.Change == "" {
+			body = fmt.Sprintf("Your user modes: +%s", ev.Modes)
+		} else {
+			body = fmt.Sprintf("Your user modes changed: %s (now +%s)", ev.Change, ev.Modes)
+		}
+		app.addStatusLine(netID, ui.Line{
+			At:        msg.TimeOrNow(),
+			Head:      "--",
+			HeadColor: tcell.ColorGray,
+			Body:      ui.PlainString(body),
+		})
+	case irc.MOTDEvent:
+		app.printMOTD(netID, msg.TimeOrNow(), ev)
+	case irc.LusersEvent:
+		app.printLusers(netID, msg.TimeOrNow(), ev)
+	case irc.VersionEvent:
+		body := fmt.Sprintf("%s runs %s", ev.Server, ev.Version)
+		if ev.Comments != "" {
+			body += " (" + ev.Comments + ")"
+		}
+		app.addReplyLines(netID, msg.TimeOrNow(), true, body)
+	case irc.AdminEvent:
+		lines := []string{fmt.Sprintf("Administrator of %s:", ev.Server)}
+		lines = append(lines, ev.Location...)
+		if ev.Email != "" {
+			lines = append(lines, "Contact: "+ev.Email)
+		}
+		app.addReplyLines(netID, msg.TimeOrNow(), true, lines...)
+	case irc.InfoEvent:
+		app.addReplyLines(netID, msg.TimeOrNow(), true, ev.Lines...)
+	case irc.ReadEvent:
+		app.win.SetRead(netID, ev.Target, ev.Timestamp)
+	case irc.BouncerNetworkEvent:
+		app.handleBouncerNetworkEvent(ev)
 	case irc.ErrorEvent:
 		if isBlackListed(msg.Command) {
 			break
@@ -729,7 +1040,7 @@ func (app *App) handleIRCEvent(ev interface{}) {
 		default:
 			panic("unreachable")
 		}
-		app.addStatusLine(ui.Line{
+		app.addStatusLine(netID, ui.Line{
 			At:   msg.TimeOrNow(),
 			Head: head,
 			Body: ui.PlainString(body),
@@ -737,9 +1048,233 @@ func (app *App) handleIRCEvent(ev interface{}) {
 	}
 }
 
+// closeBuffer removes the buffer of a channel, and forgets about it.
+func (app *App) closeBuffer(netID, channel string) {
+	app.win.RemoveBuffer(netID, channel)
+	delete(app.messageBounds, boundKey{netID, channel})
+	delete(app.joined, boundKey{netID, channel})
+}
+
+// autojoin joins the channels of the configuration (on the main connection
+// only) and the channels that were joined before a reconnection, with the keys
+// they have been joined with.
+func (app *App) autojoin(netID string, s *irc.Session) {
+	var channels, keys []string
+	seen := map[string]struct{}{}
+	add := func(channel, key string) {
+		channelCf := s.Casemap(channel)
+		if _, ok := seen[channelCf]; ok {
+			return
+		}
+		seen[channelCf] = struct{}{}
+		if k, ok := app.channelKeys[boundKey{netID, channelCf}]; ok {
+			key = k
+		} else if key != "" {
+			// So that the channel can be rejoined with its key,
+			// e.g. after a kick.
+			app.channelKeys[boundKey{netID, channelCf}] = key
+		}
+		channels = append(channels, channel)
+		keys = append(keys, key)
+	}
+	if netID == "" {
+		// Configured channels belong to the server of the
+		// configuration.  The channels of bouncer networks are kept by
+		// the bouncer.
+		for _, c := range app.cfg.Channels {
+			add(c.Name, c.Key)
+		}
+	}
+	var joined []string
+	for k := range app.joined {
+		if k.netID == netID {
+			joined = append(joined, k.target)
+		}
+	}
+	sort.Strings(joined)
+	for _, channel := range joined {
+		add(channel, "")
+	}
+	s.JoinMany(channels, keys)
+}
+
+// identify identifies to NickServ, and delays autojoin until identification
+// succeeds or identifyTimeout passes.
+func (app *App) identify(netID string, s *irc.Session) {
+	app.identifying[netID] = s
+	s.IdentifyNickServ(app.cfg.User, *app.cfg.Password)
+	go func() {
+		time.Sleep(identifyTimeout)
+		app.events <- event{
+			src:     ircEvent,
+			netID:   netID,
+			content: identifyTimeoutEvent{s},
+		}
+	}()
+}
+
+// backfillQueries fetches the messages of queries that have been missed while
+// disconnected.  Channels are backfilled once joined.
+func (app *App) backfillQueries(netID string, s *irc.Session) {
+	for k, bounds := range app.messageBounds {
+		if k.netID != netID || s.IsChannel(k.target) {
+			continue
+		}
+		s.NewHistoryRequest(k.target).
+			WithLimit(200).
+			After(bounds.last)
+	}
+}
+
+// messageBoundKey returns the key of the bounds of the conversation ev belongs
+// to: its channel, or the casemapped nickname of the other user of a query.
+func messageBoundKey(s *irc.Session, netID string, ev irc.MessageEvent) boundKey {
+	if ev.TargetIsChannel {
+		return boundKey{netID, ev.Target}
+	}
+	peer := ev.User
+	if s.IsMe(ev.User) {
+		peer = ev.Target
+	}
+	return boundKey{netID, s.Casemap(peer)}
+}
+
+// rememberKeys records the keys given to a JOIN command, so that channels can
+// be joined again after a reconnection.
+func (app *App) rememberKeys(netID string, s *irc.Session, channels, keys string) {
+	ks := strings.Split(keys, ",")
+	for i, channel := range strings.Split(channels, ",") {
+		if i < len(ks) && ks[i] != "" {
+			app.channelKeys[boundKey{netID, s.Casemap(channel)}] = ks[i]
+		}
+	}
+}
+
+// handleBouncerNetworkEvent keeps one connection per bouncer network, with its
+// own buffers.
+func (app *App) handleBouncerNetworkEvent(ev irc.BouncerNetworkEvent) {
+	stop, ok := app.networks[ev.ID]
+	if ev.Deleted {
+		if !ok {
+			return
+		}
+		close(stop)
+		delete(app.networks, ev.ID)
+		if s, ok := app.sessions[ev.ID]; ok {
+			s.Close()
+			delete(app.sessions, ev.ID)
+		}
+		app.win.RemoveNetworkBuffers(ev.ID)
+		for k := range app.joined {
+			if k.netID == ev.ID {
+				delete(app.joined, k)
+			}
+		}
+		return
+	}
+	if ok {
+		if ev.Name != "" {
+			app.win.RenameNetwork(ev.ID, ev.Name)
+		}
+		if ev.State != "" {
+			app.win.AddLine(ev.ID, "", ui.NotifyNone, ui.Line{
+				At:        time.Now(),
+				Head:      "--",
+				HeadColor: tcell.ColorGray,
+				Body:      ui.PlainSprintf("Network state changed to: %s", ev.State),
+			})
+		}
+		return
+	}
+	name := ev.Name
+	if name == "" {
+		name = ev.ID
+	}
+	stop = make(chan struct{})
+	app.networks[ev.ID] = stop
+	app.win.AddBuffer(ev.ID, name, "")
+	go app.ircLoop(ev.ID, stop)
+}
+
+// Values of the "password-fallback" setting.
+const (
+	fallbackNickServ = "nickserv"
+	fallbackPass     = "pass"
+)
+
+// Values of the "bots" setting.
+const (
+	botsShow     = "show"
+	botsHide     = "hide"
+	botsCollapse = "collapse"
+)
+
+// botPolicy returns how the given message must be shown, according to the
+// "bots" setting.
+func (app *App) botPolicy(s *irc.Session, ev irc.MessageEvent) string {
+	if !ev.Bot || !ev.TargetIsChannel {
+		return botsShow
+	}
+	policy := botsShow
+	targetCf := s.Casemap(ev.Target)
+	for channel, p := range app.cfg.Bots {
+		if channel == "*" && policy == botsShow {
+			policy = p
+		} else if s.Casemap(channel) == targetCf {
+			return p
+		}
+	}
+	return policy
+}
+
+// collapseLine dims the given line and keeps only its first row.
+func collapseLine(line ui.Line) ui.Line {
+	body := line.Body.String()
+	if i := strings.IndexByte(body, '\n'); i >= 0 {
+		body = body[:i] + "\u2026"
+	}
+	line.Body = ui.Styled(body, tcell.StyleDefault.Foreground(tcell.ColorGray).Dim(true))
+	line.Highlight = false
+	return line
+}
+
+// formatLeave returns the line shown when a user parts or quits.  Lines are
+// merged together, unless reasons are shown.
+func (app *App) formatLeave(at time.Time, user *irc.Prefix, reason string) ui.Line {
+	var body ui.StyledStringBuilder
+	body.Grow(len(user.Name) + 1)
+	body.SetStyle(tcell.StyleDefault.Foreground(tcell.ColorRed))
+	body.WriteByte('-')
+	body.SetStyle(tcell.StyleDefault.Foreground(tcell.ColorGray))
+	body.WriteString(user.Name)
+	if !app.cfg.ShowPartReasons {
+		return ui.Line{
+			At:        at,
+			Head:      "--",
+			HeadColor: tcell.ColorGray,
+			Body:      body.StyledString(),
+			Mergeable: true,
+		}
+	}
+	body.SetStyle(tcell.StyleDefault.Foreground(tcell.ColorGray).Dim(true))
+	if user.User != "" && user.Host != "" {
+		body.WriteString(fmt.Sprintf(" (%s@%s)", user.User, user.Host))
+	}
+	if reason != "" {
+		body.WriteString(": ")
+		body.WriteString(ui.IRCString(reason).String())
+	}
+	return ui.Line{
+		At:        at,
+		Head:      "--",
+		HeadColor: tcell.ColorGray,
+		Body:      body.StyledString(),
+	}
+}
+
 func isBlackListed(command string) bool {
 	switch command {
-	case "002", "003", "004", "422":
+	case "002", "003", "004":
 		// useless connection messages
 		return true
 	}
@@ -747,13 +1282,13 @@ func isBlackListed(command string) bool {
 }
 
 // isHighlight reports whether the given message content is a highlight.
-func (app *App) isHighlight(content string) bool {
-	contentCf := app.s.Casemap(content)
+func (app *App) isHighlight(s *irc.Session, content string) bool {
+	contentCf := s.Casemap(content)
 	if app.highlights == nil {
-		return strings.Contains(contentCf, app.s.NickCf())
+		return strings.Contains(contentCf, s.NickCf())
 	}
 	for _, h := range app.highlights {
-		if strings.Contains(contentCf, app.s.Casemap(h)) {
+		if strings.Contains(contentCf, s.Casemap(h)) {
 			return true
 		}
 	}
@@ -762,7 +1297,7 @@ func (app *App) isHighlight(content string) bool {
 
 // notifyHighlight executes the "on-highlight" command according to the given
 // message context.
-func (app *App) notifyHighlight(buffer, nick, content string) {
+func (app *App) notifyHighlight(netID, buffer, nick, content string) {
 	if app.cfg.OnHighlight == "" {
 		return
 	}
@@ -771,7 +1306,7 @@ func (app *App) notifyHighlight(buffer, nick, content string) {
 		return
 	}
 	here := "0"
-	if buffer == app.win.CurrentBuffer() {
+	if currentNetID, current := app.win.CurrentBuffer(); netID == currentNetID && buffer == current {
 		here = "1"
 	}
 	cmd := exec.Command(sh, "-c", app.cfg.OnHighlight)
@@ -784,7 +1319,7 @@ func (app *App) notifyHighlight(buffer, nick, content string) {
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		body := fmt.Sprintf("Failed to invoke on-highlight command: %v. Output: %q", err, string(output))
-		app.addStatusLine(ui.Line{
+		app.addStatusLine(netID, ui.Line{
 			At:        time.Now(),
 			Head:      "!!",
 			HeadColor: tcell.ColorRed,
@@ -793,21 +1328,62 @@ func (app *App) notifyHighlight(buffer, nick, content string) {
 	}
 }
 
+// markRead sends the read marker of the given buffer to the server.
+func (app *App) markRead(netID, buffer string, at time.Time) {
+	if isHome(buffer) {
+		return
+	}
+	if s, ok := app.sessions[netID]; ok {
+		s.MarkRead(buffer, at)
+	}
+}
+
 // typing sends typing notifications to the IRC server according to the user
 // input.
 func (app *App) typing() {
-	if app.s == nil || app.cfg.NoTypings {
+	s := app.session()
+	if s == nil || app.cfg.NoTypings || !s.ClientTagAllowed("+typing") {
 		return
 	}
-	buffer := app.win.CurrentBuffer()
-	if buffer == Home {
+	_, buffer := app.win.CurrentBuffer()
+	if isHome(buffer) {
 		return
 	}
 	if app.win.InputLen() == 0 {
-		app.s.TypingStop(buffer)
+		s.TypingStop(buffer)
 	} else if !app.win.InputIsCommand() {
-		app.s.Typing(app.win.CurrentBuffer())
+		s.Typing(buffer)
+		app.typingAt = time.Now()
+		idle := time.Duration(app.cfg.TypingIdle) * time.Second
+		if app.typingTimer == nil {
+			app.typingTimer = time.AfterFunc(idle, func() {
+				app.events <- event{
+					src:     uiEvent,
+					content: typingIdleEvent{},
+				}
+			})
+		} else {
+			app.typingTimer.Reset(idle)
+		}
+	}
+}
+
+// pauseTyping tells others that the user has stopped typing without clearing
+// their message, once no key has been pressed for the typing-idle delay.
+func (app *App) pauseTyping() {
+	s := app.session()
+	if s == nil || app.cfg.NoTypings {
+		return
+	}
+	idle := time.Duration(app.cfg.TypingIdle) * time.Second
+	if time.Since(app.typingAt) < idle {
+		return
+	}
+	_, buffer := app.win.CurrentBuffer()
+	if isHome(buffer) || app.win.InputLen() == 0 || app.win.InputIsCommand() {
+		return
 	}
+	s.TypingPause(buffer)
 }
 
 // completions computes the list of completions given the input text and the
@@ -819,8 +1395,12 @@ func (app *App) completions(cursorIdx int, text []rune) []ui.Completion {
 		return cs
 	}
 
-	buffer := app.win.CurrentBuffer()
-	if app.s.IsChannel(buffer) {
+	s := app.session()
+	if s == nil {
+		return cs
+	}
+	_, buffer := app.win.CurrentBuffer()
+	if s.IsChannel(buffer) {
 		cs = app.completionsChannelTopic(cs, cursorIdx, text)
 		cs = app.completionsChannelMembers(cs, cursorIdx, text)
 	}
@@ -842,17 +1422,17 @@ func (app *App) completions(cursorIdx int, text []rune) []ui.Completion {
 // - which buffer the message must be added to,
 // - the UI line,
 // - whether senpai must trigger the "on-highlight" command.
-func (app *App) formatMessage(ev irc.MessageEvent) (buffer string, line ui.Line, hlNotification bool) {
-	isFromSelf := app.s.IsMe(ev.User)
-	isHighlight := app.isHighlight(ev.Content)
+func (app *App) formatMessage(s *irc.Session, netID string, ev irc.MessageEvent) (buffer string, line ui.Line, hlNotification bool) {
+	isFromSelf := s.IsMe(ev.User)
+	isHighlight := app.isHighlight(s, ev.Content)
 	isAction := strings.HasPrefix(ev.Content, "\x01ACTION")
 	isQuery := !ev.TargetIsChannel && ev.Command == "PRIVMSG"
 	isNotice := ev.Command == "NOTICE"
 
-	if !ev.TargetIsChannel && isNotice {
-		buffer = app.win.CurrentBuffer()
+	if currentNetID, current := app.win.CurrentBuffer(); !ev.TargetIsChannel && isNotice && currentNetID == netID {
+		buffer = current
 	} else if !ev.TargetIsChannel {
-		buffer = Home
+		buffer = homeBuffer(netID)
 	} else {
 		buffer = ev.Target
 	}
@@ -900,43 +1480,157 @@ func (app *App) formatMessage(ev irc.MessageEvent) (buffer string, line ui.Line,
 		HeadColor: headColor,
 		Body:      body.StyledString(),
 		Highlight: hlLine,
+		Event:     &ev,
 	}
 	return
 }
 
 // updatePrompt changes the prompt text according to the application context.
 func (app *App) updatePrompt() {
-	buffer := app.win.CurrentBuffer()
+	_, buffer := app.win.CurrentBuffer()
+	s := app.session()
 	command := app.win.InputIsCommand()
 	var prompt ui.StyledString
-	if buffer == Home || command {
+	if isHome(buffer) || command {
 		prompt = ui.Styled(">",
 			tcell.
 				StyleDefault.
 				Foreground(tcell.Color(app.cfg.Colors.Prompt)),
 		)
-	} else if app.s == nil {
+	} else if s == nil {
 		prompt = ui.Styled("<offline>",
 			tcell.
 				StyleDefault.
 				Foreground(tcell.ColorRed),
 		)
 	} else {
-		prompt = identString(app.s.Nick())
+		prompt = identString(s.Nick())
 	}
 	app.win.SetPrompt(prompt)
 }
 
-func (app *App) printTopic(buffer string) {
+// channelListNames are the names of channel lists, by mode.
+var channelListNames = map[byte]string{
+	'b': "Ban list",
+	'e': "Exception list",
+	'I': "Invite-exception list",
+}
+
+func (app *App) printChannelList(netID string, ev irc.ChannelListEvent) {
+	buffer := ev.Channel
+	if !app.win.HasBuffer(netID, buffer) {
+		// The list was requested for a channel that is not joined.
+		buffer = homeBuffer(netID)
+	}
+	t := time.Now()
+	gray := tcell.StyleDefault.Foreground(tcell.ColorGray)
+	app.win.AddLine(netID, buffer, ui.NotifyNone, ui.Line{
+		At:        t,
+		Head:      "--",
+		HeadColor: tcell.ColorGray,
+		Body:      ui.Styled(fmt.Sprintf("%s of %s:", channelListNames[ev.Mode], ev.Channel), gray),
+	})
+	if len(ev.Entries) == 0 {
+		app.win.AddLine(netID, buffer, ui.NotifyNone, ui.Line{
+			At:   t,
+			Body: ui.Styled("  (empty)", gray),
+		})
+	}
+	for _, e := range ev.Entries {
+		var body ui.StyledStringBuilder
+		body.WriteString("  ")
+		body.WriteString(e.Mask)
+		body.SetStyle(gray)
+		if e.Setter != "" {
+			body.WriteString(" set by ")
+			body.WriteString(e.Setter)
+		}
+		if !e.Time.IsZero() {
+			body.WriteString(" on ")
+			body.WriteString(e.Time.Local().Format("Mon Jan 2 15:04:05 2006"))
+		}
+		app.win.AddLine(netID, buffer, ui.NotifyNone, ui.Line{
+			At:   t,
+			Body: body.StyledString(),
+		})
+	}
+}
+
+// isServerNoticeHidden reports whether server notices of the given category
+// are hidden by the "hide-server-notices" setting.
+func (app *App) isServerNoticeHidden(category string) bool {
+	for _, c := range app.cfg.HideServerNotices {
+		if strings.EqualFold(c, category) {
+			return true
+		}
+	}
+	return false
+}
+
+// addReplyLines prints the lines of a server reply in the home buffer, and in
+// the current buffer if it has been requested by the user.
+func (app *App) addReplyLines(netID string, at time.Time, requested bool, bodies ...string) {
+	for _, body := range bodies {
+		line := ui.Line{
+			At:        at,
+			Head:      "--",
+			HeadColor: tcell.ColorGray,
+			Body:      ui.PlainString(body),
+		}
+		if requested {
+			app.addStatusLine(netID, line)
+		} else {
+			app.win.AddLine(netID, homeBuffer(netID), ui.NotifyNone, line)
+		}
+	}
+}
+
+// printMOTD prints the message of the day.  It is folded into one line when
+// sent on connection, and shown entirely when requested with /motd.
+func (app *App) printMOTD(netID string, at time.Time, ev irc.MOTDEvent) {
+	if !ev.Requested {
+		if len(ev.Lines) != 0 {
+			app.addReplyLines(netID, at, false, fmt.Sprintf("Message of the day: %d lines, type /motd to read it", len(ev.Lines)))
+		}
+		return
+	}
+	if len(ev.Lines) == 0 {
+		app.addReplyLines(netID, at, true, "No message of the day")
+		return
+	}
+	app.addReplyLines(netID, at, true, append([]string{"Message of the day:"}, ev.Lines...)...)
+}
+
+// printLusers prints the statistics of the network on one line.
+func (app *App) printLusers(netID string, at time.Time, ev irc.LusersEvent) {
+	parts := []string{ev.Client}
+	if ev.Operators != 0 {
+		parts = append(parts, fmt.Sprintf("%d operators", ev.Operators))
+	}
+	if ev.Unknown != 0 {
+		parts = append(parts, fmt.Sprintf("%d unknown connections", ev.Unknown))
+	}
+	if ev.Channels != 0 {
+		parts = append(parts, fmt.Sprintf("%d channels", ev.Channels))
+	}
+	parts = append(parts, ev.Me)
+	app.addReplyLines(netID, at, ev.Requested, strings.Join(parts, ", "))
+}
+
+func (app *App) printTopic(netID, buffer string) {
+	s, ok := app.sessions[netID]
+	if !ok {
+		return
+	}
 	var body string
 
-	topic, who, at := app.s.Topic(buffer)
+	topic, who, at := s.Topic(buffer)
 	if who == nil {
 		body = fmt.Sprintf("Topic: %s", topic)
 	} else {
 		body = fmt.Sprintf("Topic (by %s, %s): %s", who, at.Local().Format("Mon Jan 2 15:04:05"), topic)
 	}
-	app.win.AddLine(buffer, ui.NotifyNone, ui.Line{
+	app.win.AddLine(netID, buffer, ui.NotifyNone, ui.Line{
 		At:        time.Now(),
 		Head:      "--",
 		HeadColor: tcell.ColorGray,
@@ -0,0 +1,49 @@
+package senpai
+
+import (
+	"io"
+	"os/exec"
+)
+
+// commandConn is the pair of standard streams of a running process.
+type commandConn struct {
+	io.ReadCloser
+	io.WriteCloser
+	cmd *exec.Cmd
+}
+
+// startCommand runs the given command through "sh -c" and returns a stream
+// that reads from its standard output and writes to its standard input.
+func startCommand(command string) (io.ReadWriteCloser, error) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(sh, "-c", command)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+	return &commandConn{
+		ReadCloser:  stdout,
+		WriteCloser: stdin,
+		cmd:         cmd,
+	}, nil
+}
+
+// Close closes the standard input of the process, kills it and waits for it
+// to exit.
+func (c *commandConn) Close() error {
+	err := c.WriteCloser.Close()
+	_ = c.cmd.Process.Kill()
+	_ = c.cmd.Wait()
+	return err
+}
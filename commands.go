@@ -121,29 +121,198 @@ func init() {
 			Desc:      "switch to the buffer containing a substring",
 			Handle:    commandDoBuffer,
 		},
+		"KICK": {
+			MinArgs: 1,
+			MaxArgs: 2,
+			Usage:   "<nick>[,<nick>...] [reason]",
+			Desc:    "kick users from the current channel",
+			Handle:  commandDoKick,
+		},
+		"OP": {
+			MinArgs: 1,
+			MaxArgs: maxNickArgs,
+			Usage:   "<nicks...>",
+			Desc:    "give channel operator status to users",
+			Handle:  commandDoMemberMode('+', 'o'),
+		},
+		"DEOP": {
+			MinArgs: 1,
+			MaxArgs: maxNickArgs,
+			Usage:   "<nicks...>",
+			Desc:    "take channel operator status from users",
+			Handle:  commandDoMemberMode('-', 'o'),
+		},
+		"VOICE": {
+			MinArgs: 1,
+			MaxArgs: maxNickArgs,
+			Usage:   "<nicks...>",
+			Desc:    "give voice to users",
+			Handle:  commandDoMemberMode('+', 'v'),
+		},
+		"DEVOICE": {
+			MinArgs: 1,
+			MaxArgs: maxNickArgs,
+			Usage:   "<nicks...>",
+			Desc:    "take voice from users",
+			Handle:  commandDoMemberMode('-', 'v'),
+		},
+		"QUIET": {
+			MinArgs: 1,
+			MaxArgs: maxNickArgs,
+			Usage:   "<nicks/masks...>",
+			Desc:    "prevent users from talking in the current channel",
+			Handle:  commandDoQuiet,
+		},
+		"BANLIST": {
+			AllowHome: true,
+			MaxArgs:   1,
+			Usage:     "[channel]",
+			Desc:      "show the ban list of the current or given channel",
+			Handle:    commandDoChannelList('b'),
+		},
+		"EXCEPTLIST": {
+			AllowHome: true,
+			MaxArgs:   1,
+			Usage:     "[channel]",
+			Desc:      "show the ban exception list of the current or given channel",
+			Handle:    commandDoChannelList('e'),
+		},
+		"INVEXLIST": {
+			AllowHome: true,
+			MaxArgs:   1,
+			Usage:     "[channel]",
+			Desc:      "show the invite exception list of the current or given channel",
+			Handle:    commandDoChannelList('I'),
+		},
+		"BAN": {
+			MinArgs: 1,
+			MaxArgs: 1,
+			Usage:   "<nick/mask>",
+			Desc:    "ban someone from the current channel",
+			Handle:  commandDoBan("+b"),
+		},
+		"UNBAN": {
+			MinArgs: 1,
+			MaxArgs: 1,
+			Usage:   "<nick/mask>",
+			Desc:    "remove a ban from the current channel",
+			Handle:  commandDoBan("-b"),
+		},
+		"KICKBAN": {
+			MinArgs: 1,
+			MaxArgs: 2,
+			Usage:   "<nick> [reason]",
+			Desc:    "ban someone from the current channel and kick them",
+			Handle:  commandDoKickBan,
+		},
+		"BOUNCER": {
+			AllowHome: true,
+			MinArgs:   1,
+			MaxArgs:   3,
+			Usage:     "<subcommand> [args]",
+			Desc:      "manage bouncer networks (e.g. addnetwork host=irc.example.org)",
+			Handle:    commandDoBouncer,
+		},
+		"CAP": {
+			AllowHome: true,
+			MaxArgs:   2,
+			Usage:     "[req [-]<capability>]",
+			Desc:      "list IRC capabilities, or enable (disable with -) one",
+			Handle:    commandDoCap,
+		},
+		"OPER": {
+			AllowHome: true,
+			MinArgs:   2,
+			MaxArgs:   2,
+			Usage:     "<name> <password>",
+			Desc:      "become an IRC operator",
+			Handle:    commandDoOper,
+		},
+		"MOTD": {
+			AllowHome: true,
+			MaxArgs:   1,
+			Usage:     "[server]",
+			Desc:      "show the message of the day of the server",
+			Handle:    commandDoMOTD,
+		},
+		"LUSERS": {
+			AllowHome: true,
+			Desc:      "show statistics about the network",
+			Handle: commandDoServerRequest(func(s *irc.Session, _ string) {
+				s.Lusers()
+			}),
+		},
+		"VERSION": {
+			AllowHome: true,
+			MaxArgs:   1,
+			Usage:     "[server]",
+			Desc:      "show the version of the server",
+			Handle:    commandDoServerRequest((*irc.Session).Version),
+		},
+		"ADMIN": {
+			AllowHome: true,
+			MaxArgs:   1,
+			Usage:     "[server]",
+			Desc:      "show who administrates the server",
+			Handle:    commandDoServerRequest((*irc.Session).Admin),
+		},
+		"INFO": {
+			AllowHome: true,
+			MaxArgs:   1,
+			Usage:     "[server]",
+			Desc:      "show information about the server software",
+			Handle:    commandDoServerRequest((*irc.Session).Info),
+		},
+	}
+}
+
+// maxNickArgs is the maximum number of nicks given to commands such as OP.
+const maxNickArgs = 64
+
+var errOffline = fmt.Errorf("not connected")
+
+// requireSession returns the session of the current buffer's network, or
+// errOffline.
+func (app *App) requireSession() (*irc.Session, error) {
+	s := app.session()
+	if s == nil {
+		return nil, errOffline
 	}
+	return s, nil
+}
+
+// echoMessage prints a message sent by the user when the server does not echo
+// it back.
+func (app *App) echoMessage(s *irc.Session, netID, target, content string) {
+	if s.HasCapability("echo-message") {
+		return
+	}
+	buffer, line, _ := app.formatMessage(s, netID, irc.MessageEvent{
+		User:            s.Nick(),
+		Prefix:          &irc.Prefix{Name: s.Nick()},
+		Target:          target,
+		TargetIsChannel: true,
+		Command:         "PRIVMSG",
+		Content:         content,
+		Time:            time.Now(),
+	})
+	app.win.AddLine(netID, buffer, ui.NotifyNone, line)
 }
 
 func noCommand(app *App, buffer, content string) error {
 	// You can't send messages to home buffer, and it might get
 	// delivered to a user "home" without a bouncer, which will be bad.
-	if buffer == Home {
+	if isHome(buffer) {
 		return fmt.Errorf("Can't send message to home")
 	}
-
-	app.s.PrivMsg(buffer, content)
-	if !app.s.HasCapability("echo-message") {
-		buffer, line, _ := app.formatMessage(irc.MessageEvent{
-			User:            app.s.Nick(),
-			Target:          buffer,
-			TargetIsChannel: true,
-			Command:         "PRIVMSG",
-			Content:         content,
-			Time:            time.Now(),
-		})
-		app.win.AddLine(buffer, ui.NotifyNone, line)
+	s, err := app.requireSession()
+	if err != nil {
+		return err
 	}
 
+	s.PrivMsg(buffer, content)
+	app.echoMessage(s, s.NetID(), buffer, content)
+
 	return nil
 }
 
@@ -164,8 +333,9 @@ func commandDoBuffer(app *App, args []string) error {
 
 func commandDoHelp(app *App, args []string) (err error) {
 	t := time.Now()
+	netID, buffer := app.win.CurrentBuffer()
 	if len(args) == 0 {
-		app.win.AddLine(app.win.CurrentBuffer(), ui.NotifyNone, ui.Line{
+		app.win.AddLine(netID, buffer, ui.NotifyNone, ui.Line{
 			At:   t,
 			Head: "--",
 			Body: ui.PlainString("Available commands:"),
@@ -174,22 +344,22 @@ func commandDoHelp(app *App, args []string) (err error) {
 			if cmd.Desc == "" {
 				continue
 			}
-			app.win.AddLine(app.win.CurrentBuffer(), ui.NotifyNone, ui.Line{
+			app.win.AddLine(netID, buffer, ui.NotifyNone, ui.Line{
 				At:   t,
 				Body: ui.PlainSprintf("  \x02%s\x02 %s", cmdName, cmd.Usage),
 			})
-			app.win.AddLine(app.win.CurrentBuffer(), ui.NotifyNone, ui.Line{
+			app.win.AddLine(netID, buffer, ui.NotifyNone, ui.Line{
 				At:   t,
 				Body: ui.PlainSprintf("    %s", cmd.Desc),
 			})
-			app.win.AddLine(app.win.CurrentBuffer(), ui.NotifyNone, ui.Line{
+			app.win.AddLine(netID, buffer, ui.NotifyNone, ui.Line{
 				At: t,
 			})
 		}
 	} else {
 		search := strings.ToUpper(args[0])
 		found := false
-		app.win.AddLine(app.win.CurrentBuffer(), ui.NotifyNone, ui.Line{
+		app.win.AddLine(netID, buffer, ui.NotifyNone, ui.Line{
 			At:   t,
 			Head: "--",
 			Body: ui.PlainSprintf("Commands that match \"%s\":", search),
@@ -205,21 +375,21 @@ func commandDoHelp(app *App, args []string) (err error) {
 			usage.SetStyle(tcell.StyleDefault)
 			usage.WriteByte(' ')
 			usage.WriteString(cmd.Usage)
-			app.win.AddLine(app.win.CurrentBuffer(), ui.NotifyNone, ui.Line{
+			app.win.AddLine(netID, buffer, ui.NotifyNone, ui.Line{
 				At:   t,
 				Body: usage.StyledString(),
 			})
-			app.win.AddLine(app.win.CurrentBuffer(), ui.NotifyNone, ui.Line{
+			app.win.AddLine(netID, buffer, ui.NotifyNone, ui.Line{
 				At:   t,
 				Body: ui.PlainSprintf("  %s", cmd.Desc),
 			})
-			app.win.AddLine(app.win.CurrentBuffer(), ui.NotifyNone, ui.Line{
+			app.win.AddLine(netID, buffer, ui.NotifyNone, ui.Line{
 				At: t,
 			})
 			found = true
 		}
 		if !found {
-			app.win.AddLine(app.win.CurrentBuffer(), ui.NotifyNone, ui.Line{
+			app.win.AddLine(netID, buffer, ui.NotifyNone, ui.Line{
 				At:   t,
 				Body: ui.PlainSprintf("  no command matches %q", args[0]),
 			})
@@ -233,55 +403,52 @@ func commandDoJoin(app *App, args []string) (err error) {
 	if len(args) == 2 {
 		key = args[1]
 	}
-	app.s.Join(args[0], key)
+	s, err := app.requireSession()
+	if err != nil {
+		return err
+	}
+	app.rememberKeys(s.NetID(), s, args[0], key)
+	s.Join(args[0], key)
 	return
 }
 
 func commandDoMe(app *App, args []string) (err error) {
-	buffer := app.win.CurrentBuffer()
-	if buffer == Home {
-		buffer = app.lastQuery
+	netID, buffer := app.win.CurrentBuffer()
+	if isHome(buffer) {
+		netID, buffer = app.lastQueryNetID, app.lastQuery
 	}
-	content := fmt.Sprintf("\x01ACTION %s\x01", args[0])
-	app.s.PrivMsg(buffer, content)
-	if !app.s.HasCapability("echo-message") {
-		buffer, line, _ := app.formatMessage(irc.MessageEvent{
-			User:            app.s.Nick(),
-			Target:          buffer,
-			TargetIsChannel: true,
-			Command:         "PRIVMSG",
-			Content:         content,
-			Time:            time.Now(),
-		})
-		app.win.AddLine(buffer, ui.NotifyNone, line)
+	s, ok := app.sessions[netID]
+	if !ok {
+		return errOffline
 	}
+	content := fmt.Sprintf("\x01ACTION %s\x01", args[0])
+	s.PrivMsg(buffer, content)
+	app.echoMessage(s, netID, buffer, content)
 	return
 }
 
 func commandDoMsg(app *App, args []string) (err error) {
 	target := args[0]
 	content := args[1]
-	app.s.PrivMsg(target, content)
-	if !app.s.HasCapability("echo-message") {
-		buffer, line, _ := app.formatMessage(irc.MessageEvent{
-			User:            app.s.Nick(),
-			Target:          target,
-			TargetIsChannel: true,
-			Command:         "PRIVMSG",
-			Content:         content,
-			Time:            time.Now(),
-		})
-		app.win.AddLine(buffer, ui.NotifyNone, line)
+	s, err := app.requireSession()
+	if err != nil {
+		return err
 	}
+	s.PrivMsg(target, content)
+	app.echoMessage(s, s.NetID(), target, content)
 	return
 }
 
 func commandDoNames(app *App, args []string) (err error) {
-	buffer := app.win.CurrentBuffer()
+	s, err := app.requireSession()
+	if err != nil {
+		return err
+	}
+	netID, buffer := app.win.CurrentBuffer()
 	var sb ui.StyledStringBuilder
 	sb.SetStyle(tcell.StyleDefault.Foreground(tcell.ColorGrey))
 	sb.WriteString("Names: ")
-	for _, name := range app.s.Names(buffer) {
+	for _, name := range s.Names(buffer) {
 		if name.PowerLevel != "" {
 			sb.SetStyle(tcell.StyleDefault.Foreground(tcell.ColorGreen))
 			sb.WriteString(name.PowerLevel)
@@ -292,7 +459,7 @@ func commandDoNames(app *App, args []string) (err error) {
 	}
 	body := sb.StyledString()
 	// TODO remove last space
-	app.win.AddLine(buffer, ui.NotifyNone, ui.Line{
+	app.win.AddLine(netID, buffer, ui.NotifyNone, ui.Line{
 		At:        time.Now(),
 		Head:      "--",
 		HeadColor: tcell.ColorGray,
@@ -306,7 +473,11 @@ func commandDoNick(app *App, args []string) (err error) {
 	if i := strings.IndexAny(nick, " :@!*?"); i >= 0 {
 		return fmt.Errorf("illegal char %q in nickname", nick[i])
 	}
-	app.s.ChangeNick(nick)
+	s, err := app.requireSession()
+	if err != nil {
+		return err
+	}
+	s.ChangeNick(nick)
 	return
 }
 
@@ -315,15 +486,37 @@ func commandDoMode(app *App, args []string) (err error) {
 	flags := args[1]
 	modeArgs := args[2:]
 
-	app.s.ChangeMode(channel, flags, modeArgs)
+	s, err := app.requireSession()
+	if err != nil {
+		return err
+	}
+	s.ChangeMode(channel, flags, modeArgs)
 	return
 }
 
 func commandDoPart(app *App, args []string) (err error) {
-	channel := app.win.CurrentBuffer()
+	netID, channel := app.win.CurrentBuffer()
+	if channel == serverBuffer && len(args) == 0 {
+		// It is opened again on the next server notice.
+		app.win.RemoveBuffer(netID, serverBuffer)
+		return
+	}
+	s := app.session()
+	if s == nil {
+		// The channel is not joined while offline, just close its
+		// buffer.
+		if 0 < len(args) && app.win.HasBuffer(netID, args[0]) {
+			channel = args[0]
+		}
+		if isHome(channel) {
+			return errOffline
+		}
+		app.closeBuffer(netID, channel)
+		return
+	}
 	reason := ""
 	if 0 < len(args) {
-		if app.s.IsChannel(args[0]) {
+		if s.IsChannel(args[0]) {
 			channel = args[0]
 			if 1 < len(args) {
 				reason = args[1]
@@ -333,10 +526,14 @@ func commandDoPart(app *App, args []string) (err error) {
 		}
 	}
 
-	if channel != Home {
-		app.s.Part(channel, reason)
-	} else {
+	if isHome(channel) {
 		err = fmt.Errorf("cannot part home!")
+	} else if s.IsChannel(channel) && !s.IsJoined(channel) {
+		// For example after a kick: the server would only reply
+		// that we are not on the channel.
+		app.closeBuffer(netID, channel)
+	} else {
+		s.Part(channel, reason)
 	}
 	return
 }
@@ -346,43 +543,238 @@ func commandDoQuit(app *App, args []string) (err error) {
 	if 0 < len(args) {
 		reason = args[0]
 	}
-	if app.s != nil {
-		app.s.Quit(reason)
+	for _, s := range app.sessions {
+		s.Quit(reason)
 	}
 	app.win.Exit()
 	return
 }
 
 func commandDoQuote(app *App, args []string) (err error) {
-	app.s.SendRaw(args[0])
+	s, err := app.requireSession()
+	if err != nil {
+		return err
+	}
+	s.SendRaw(args[0])
 	return
 }
 
 func commandDoR(app *App, args []string) (err error) {
-	app.s.PrivMsg(app.lastQuery, args[0])
-	if !app.s.HasCapability("echo-message") {
-		buffer, line, _ := app.formatMessage(irc.MessageEvent{
-			User:            app.s.Nick(),
-			Target:          app.lastQuery,
-			TargetIsChannel: true,
-			Command:         "PRIVMSG",
-			Content:         args[0],
-			Time:            time.Now(),
-		})
-		app.win.AddLine(buffer, ui.NotifyNone, line)
+	s, ok := app.sessions[app.lastQueryNetID]
+	if !ok {
+		return errOffline
 	}
+	s.PrivMsg(app.lastQuery, args[0])
+	app.echoMessage(s, app.lastQueryNetID, app.lastQuery, args[0])
 	return
 }
 
 func commandDoTopic(app *App, args []string) (err error) {
+	netID, buffer := app.win.CurrentBuffer()
 	if len(args) == 0 {
-		app.printTopic(app.win.CurrentBuffer())
+		app.printTopic(netID, buffer)
 	} else {
-		app.s.ChangeTopic(app.win.CurrentBuffer(), args[0])
+		s, err := app.requireSession()
+		if err != nil {
+			return err
+		}
+		s.ChangeTopic(buffer, args[0])
 	}
 	return
 }
 
+func commandDoKick(app *App, args []string) (err error) {
+	s, err := app.requireSession()
+	if err != nil {
+		return err
+	}
+	_, channel := app.win.CurrentBuffer()
+	reason := ""
+	if 1 < len(args) {
+		reason = args[1]
+	}
+	for _, nick := range strings.Split(args[0], ",") {
+		if nick != "" {
+			s.Kick(channel, nick, reason)
+		}
+	}
+	return
+}
+
+func commandDoMemberMode(flag, mode byte) func(app *App, args []string) error {
+	return func(app *App, args []string) error {
+		s, err := app.requireSession()
+		if err != nil {
+			return err
+		}
+		_, channel := app.win.CurrentBuffer()
+		s.ChangeMemberModes(channel, flag, mode, args)
+		return nil
+	}
+}
+
+func commandDoQuiet(app *App, args []string) (err error) {
+	s, err := app.requireSession()
+	if err != nil {
+		return err
+	}
+	if !s.SupportsQuiet() {
+		return fmt.Errorf("the server does not support quiets")
+	}
+	_, channel := app.win.CurrentBuffer()
+	masks := make([]string, len(args))
+	for i, arg := range args {
+		masks[i] = s.BanMask(arg)
+	}
+	s.ChangeMemberModes(channel, '+', 'q', masks)
+	return
+}
+
+func commandDoChannelList(mode byte) func(app *App, args []string) error {
+	return func(app *App, args []string) error {
+		s, err := app.requireSession()
+		if err != nil {
+			return err
+		}
+		_, channel := app.win.CurrentBuffer()
+		if 0 < len(args) {
+			channel = args[0]
+		}
+		if !s.IsChannel(channel) {
+			return fmt.Errorf("%q is not a channel", channel)
+		}
+		s.ChannelList(channel, mode)
+		return nil
+	}
+}
+
+func commandDoBan(flags string) func(app *App, args []string) error {
+	return func(app *App, args []string) error {
+		s, err := app.requireSession()
+		if err != nil {
+			return err
+		}
+		_, channel := app.win.CurrentBuffer()
+		s.ChangeMode(channel, flags, []string{s.BanMask(args[0])})
+		return nil
+	}
+}
+
+func commandDoKickBan(app *App, args []string) (err error) {
+	s, err := app.requireSession()
+	if err != nil {
+		return err
+	}
+	_, channel := app.win.CurrentBuffer()
+	nick := args[0]
+	reason := ""
+	if 1 < len(args) {
+		reason = args[1]
+	}
+	s.ChangeMode(channel, "+b", []string{s.BanMask(nick)})
+	s.Kick(channel, nick, reason)
+	return
+}
+
+func commandDoBouncer(app *App, args []string) (err error) {
+	// Bouncer networks are managed through the main connection.
+	s, ok := app.sessions[""]
+	if !ok {
+		return errOffline
+	}
+	if !s.HasCapability("soju.im/bouncer-networks") {
+		return fmt.Errorf("the server does not support bouncer networks")
+	}
+	s.Bouncer(args[0], args[1:]...)
+	return
+}
+
+func commandDoCap(app *App, args []string) (err error) {
+	s, err := app.requireSession()
+	if err != nil {
+		return err
+	}
+	if len(args) == 2 && strings.ToUpper(args[0]) == "REQ" {
+		capability := strings.TrimPrefix(args[1], "-")
+		return s.RequestCapability(capability, capability == args[1])
+	}
+	if len(args) != 0 {
+		return fmt.Errorf("usage: cap [req [-]<capability>]")
+	}
+	var enabled, available ui.StyledStringBuilder
+	enabled.WriteString("Enabled capabilities:")
+	available.WriteString("Other available capabilities:")
+	for _, c := range s.Capabilities() {
+		sb := &available
+		if c.Enable {
+			sb = &enabled
+		}
+		sb.WriteByte(' ')
+		sb.WriteString(c.Name)
+		if c.Value != "" {
+			sb.SetStyle(tcell.StyleDefault.Foreground(tcell.ColorGray))
+			sb.WriteByte('=')
+			sb.WriteString(c.Value)
+			sb.SetStyle(tcell.StyleDefault)
+		}
+	}
+	netID, buffer := app.win.CurrentBuffer()
+	for _, sb := range []*ui.StyledStringBuilder{&enabled, &available} {
+		app.win.AddLine(netID, buffer, ui.NotifyNone, ui.Line{
+			At:        time.Now(),
+			Head:      "--",
+			HeadColor: tcell.ColorGray,
+			Body:      sb.StyledString(),
+		})
+	}
+	return
+}
+
+func commandDoOper(app *App, args []string) (err error) {
+	s, err := app.requireSession()
+	if err != nil {
+		return err
+	}
+	s.Oper(args[0], args[1])
+	return
+}
+
+func commandDoMOTD(app *App, args []string) (err error) {
+	s, err := app.requireSession()
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		// Show the MOTD received on connection without asking again.
+		if lines, ok := s.LastMOTD(); ok {
+			netID, _ := app.win.CurrentBuffer()
+			app.printMOTD(netID, time.Now(), irc.MOTDEvent{
+				Lines:     lines,
+				Requested: true,
+			})
+			return
+		}
+	}
+	return commandDoServerRequest((*irc.Session).MOTD)(app, args)
+}
+
+// commandDoServerRequest returns the handler of commands such as MOTD, which
+// take an optional server name.
+func commandDoServerRequest(request func(s *irc.Session, server string)) func(app *App, args []string) error {
+	return func(app *App, args []string) (err error) {
+		s, err := app.requireSession()
+		if err != nil {
+			return err
+		}
+		server := ""
+		if len(args) == 1 {
+			server = args[0]
+		}
+		request(s, server)
+		return
+	}
+}
+
 // implemented from https://golang.org/src/strings/strings.go?s=8055:8085#L310
 func fieldsN(s string, n int) []string {
 	s = strings.TrimSpace(s)
@@ -463,8 +855,13 @@ func (app *App) handleInput(buffer, content string) error {
 
 	var chosenCMDName string
 	var found bool
+	if _, ok := commands[cmdName]; ok {
+		// Exact matches take precedence (e.g. BAN over BANLIST).
+		chosenCMDName = cmdName
+		found = true
+	}
 	for key := range commands {
-		if !strings.HasPrefix(key, cmdName) {
+		if chosenCMDName == cmdName || !strings.HasPrefix(key, cmdName) {
 			continue
 		}
 		if found {
@@ -487,7 +884,7 @@ func (app *App) handleInput(buffer, content string) error {
 	if len(args) < cmd.MinArgs {
 		return fmt.Errorf("usage: %s %s", cmdName, cmd.Usage)
 	}
-	if buffer == Home && !cmd.AllowHome {
+	if isHome(buffer) && !cmd.AllowHome {
 		return fmt.Errorf("command %q cannot be executed from home", cmdName)
 	}
 
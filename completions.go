@@ -3,6 +3,7 @@ package senpai
 import (
 	"strings"
 
+	"git.sr.ht/~taiite/senpai/irc"
 	"git.sr.ht/~taiite/senpai/ui"
 )
 
@@ -18,9 +19,15 @@ func (app *App) completionsChannelMembers(cs []ui.Completion, cursorIdx int, tex
 	if len(word) == 0 {
 		return cs
 	}
-	wordCf := app.s.Casemap(string(word))
-	for _, name := range app.s.Names(app.win.CurrentBuffer()) {
-		if strings.HasPrefix(app.s.Casemap(name.Name.Name), wordCf) {
+	s := app.session()
+	_, buffer := app.win.CurrentBuffer()
+	wordCf := s.Casemap(string(word))
+	wants := memberFilter(text)
+	for _, name := range s.Names(buffer) {
+		if wants != nil && !wants(s, name) {
+			continue
+		}
+		if strings.HasPrefix(s.Casemap(name.Name.Name), wordCf) {
 			nickComp := []rune(name.Name.Name)
 			if start == 0 {
 				nickComp = append(nickComp, ':')
@@ -41,11 +48,37 @@ func (app *App) completionsChannelMembers(cs []ui.Completion, cursorIdx int, tex
 	return cs
 }
 
+// memberFilter returns which members are worth completing for the command in
+// text, or nil if all members are.  For example, only operators are completed
+// after "/deop".
+func memberFilter(text []rune) func(s *irc.Session, m irc.Member) bool {
+	filters := []struct {
+		prefix string
+		mode   byte
+		has    bool
+	}{
+		{"/op ", 'o', false},
+		{"/deop ", 'o', true},
+		{"/voice ", 'v', false},
+		{"/devoice ", 'v', true},
+	}
+	for _, f := range filters {
+		f := f
+		if hasPrefix(text, []rune(f.prefix)) {
+			return func(s *irc.Session, m irc.Member) bool {
+				return s.HasPrefixMode(m.PowerLevel, f.mode) == f.has
+			}
+		}
+	}
+	return nil
+}
+
 func (app *App) completionsChannelTopic(cs []ui.Completion, cursorIdx int, text []rune) []ui.Completion {
 	if !hasPrefix(text, []rune("/topic ")) {
 		return cs
 	}
-	topic, _, _ := app.s.Topic(app.win.CurrentBuffer())
+	_, buffer := app.win.CurrentBuffer()
+	topic, _, _ := app.session().Topic(buffer)
 	if cursorIdx == len(text) {
 		compText := append(text, []rune(topic)...)
 		cs = append(cs, ui.Completion{
@@ -62,6 +95,7 @@ func (app *App) completionsMsg(cs []ui.Completion, cursorIdx int, text []rune) [
 	}
 	// Check if the first word (target) is already written and complete (in
 	// which case we don't have completions to provide).
+	s := app.session()
 	var word string
 	hasMetALetter := false
 	for i := 5; i < cursorIdx; i += 1 {
@@ -69,15 +103,15 @@ func (app *App) completionsMsg(cs []ui.Completion, cursorIdx int, text []rune) [
 			return cs
 		}
 		if !hasMetALetter && text[i] != ' ' {
-			word = app.s.Casemap(string(text[i:cursorIdx]))
+			word = s.Casemap(string(text[i:cursorIdx]))
 			hasMetALetter = true
 		}
 	}
 	if word == "" {
 		return cs
 	}
-	for _, user := range app.s.Users() {
-		if strings.HasPrefix(app.s.Casemap(user), word) {
+	for _, user := range s.Users() {
+		if strings.HasPrefix(s.Casemap(user), word) {
 			nickComp := append([]rune(user), ' ')
 			c := make([]rune, len(text)+5+len(nickComp)-cursorIdx)
 			copy(c[:5], []rune("/msg "))
@@ -8,6 +8,7 @@ import (
 	"strings"
 
 	"github.com/gdamore/tcell/v2"
+	"golang.org/x/text/encoding/htmlindex"
 
 	"gopkg.in/yaml.v2"
 )
@@ -46,17 +47,66 @@ func (c *Color) UnmarshalText(data []byte) error {
 	return nil
 }
 
+// ChannelConfig is a channel to join automatically.  It is written either as
+// "#channel", "#channel key", or as a mapping with "name" and "key".
+type ChannelConfig struct {
+	Name string
+	Key  string
+}
+
+func (c *ChannelConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err == nil {
+		fields := strings.Fields(s)
+		if len(fields) == 0 || 2 < len(fields) {
+			return fmt.Errorf("invalid channel %q", s)
+		}
+		c.Name = fields[0]
+		if len(fields) == 2 {
+			c.Key = fields[1]
+		}
+		return nil
+	}
+	var m struct {
+		Name string
+		Key  string
+	}
+	if err := unmarshal(&m); err != nil {
+		return err
+	}
+	if m.Name == "" {
+		return errors.New("channel name is required")
+	}
+	*c = ChannelConfig(m)
+	return nil
+}
+
 type Config struct {
-	Addr     string
-	Nick     string
-	Real     string
-	User     string
-	Password *string
-	NoTLS    bool `yaml:"no-tls"`
-	Channels []string
+	Addr           string
+	Nick           string
+	Real           string
+	User           string
+	Password       *string
+	NoTLS          bool `yaml:"no-tls"`
+	Proxy          string
+	ConnectCommand string `yaml:"connect-command"`
+	Encoding       string
+	Channels       []ChannelConfig
+
+	PasswordFallback string `yaml:"password-fallback"`
+
+	AutoRejoinOnKick bool `yaml:"auto-rejoin-on-kick"`
+	ShowPartReasons  bool `yaml:"show-part-reasons"`
+
+	Bots map[string]string
+
+	HideServerNotices []string `yaml:"hide-server-notices"`
+
+	DisableCaps []string `yaml:"disable-caps"`
 
-	NoTypings bool `yaml:"no-typings"`
-	Mouse     *bool
+	NoTypings  bool `yaml:"no-typings"`
+	TypingIdle int  `yaml:"typing-idle"`
+	Mouse      *bool
 
 	Highlights     []string
 	OnHighlight    string `yaml:"on-highlight"`
@@ -76,7 +126,7 @@ func ParseConfig(buf []byte) (cfg Config, err error) {
 	if err != nil {
 		return cfg, err
 	}
-	if cfg.Addr == "" {
+	if cfg.Addr == "" && cfg.ConnectCommand == "" {
 		return cfg, errors.New("addr is required")
 	}
 	if cfg.Nick == "" {
@@ -88,6 +138,26 @@ func ParseConfig(buf []byte) (cfg Config, err error) {
 	if cfg.Real == "" {
 		cfg.Real = cfg.Nick
 	}
+	if cfg.Encoding != "" {
+		if _, err := htmlindex.Get(cfg.Encoding); err != nil {
+			return cfg, fmt.Errorf("unknown encoding %q", cfg.Encoding)
+		}
+	}
+	switch cfg.PasswordFallback {
+	case "", fallbackNickServ, fallbackPass:
+	default:
+		return cfg, fmt.Errorf("invalid password-fallback %q (must be nickserv or pass)", cfg.PasswordFallback)
+	}
+	for channel, policy := range cfg.Bots {
+		switch policy {
+		case botsShow, botsHide, botsCollapse:
+		default:
+			return cfg, fmt.Errorf("invalid bots setting for %q: %q (must be show, hide or collapse)", channel, policy)
+		}
+	}
+	if cfg.TypingIdle <= 0 {
+		cfg.TypingIdle = 5
+	}
 	if cfg.NickColWidth <= 0 {
 		cfg.NickColWidth = 16
 	}
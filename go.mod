@@ -5,7 +5,9 @@ go 1.14
 require (
 	github.com/gdamore/tcell/v2 v2.3.11
 	github.com/mattn/go-runewidth v0.0.10
+	github.com/rivo/uniseg v0.1.0
 	golang.org/x/term v0.0.0-20201210144234-2321bbc49cbf
+	golang.org/x/text v0.3.0
 	golang.org/x/time v0.0.0-20210611083556-38a9dc6acbc6
 	gopkg.in/yaml.v2 v2.3.0
 )
@@ -3,19 +3,44 @@ package irc
 import (
 	"bufio"
 	"fmt"
-	"net"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
 )
 
 const chanCapacity = 64
 
-func ChanInOut(conn net.Conn) (in <-chan Message, out chan<- Message) {
+// ChanInOut reads and writes IRC messages from and to the given stream (a TCP
+// or TLS connection, a unix socket, the standard streams of a process...),
+// through channels.  The stream is closed when the out channel is closed.
+//
+// Incoming lines that are not valid UTF-8 are decoded with fallback, unless it
+// is nil.  When fallback is an ISO-2022 encoding, lines that contain its escape
+// sequences are decoded too.
+func ChanInOut(conn io.ReadWriteCloser, fallback encoding.Encoding) (in <-chan Message, out chan<- Message) {
 	in_ := make(chan Message, chanCapacity)
 	out_ := make(chan Message, chanCapacity)
 
 	go func() {
+		var decoder *encoding.Decoder
+		var iso2022 bool
+		if fallback != nil {
+			decoder = fallback.NewDecoder()
+			name, _ := htmlindex.Name(fallback)
+			iso2022 = strings.HasPrefix(name, "iso-2022-")
+		}
 		r := bufio.NewScanner(conn)
 		for r.Scan() {
 			line := r.Text()
+			if decoder != nil && needsDecoding(line, iso2022) {
+				decoded, err := decoder.String(line)
+				if err == nil {
+					line = decoded
+				}
+			}
 			msg, err := ParseMessage(line)
 			if err != nil {
 				continue
@@ -38,3 +63,13 @@ func ChanInOut(conn net.Conn) (in <-chan Message, out chan<- Message) {
 
 	return in_, out_
 }
+
+// needsDecoding reports whether line is encoded with a legacy encoding.  Lines
+// in ISO-2022 encodings are valid UTF-8, but switch character sets with
+// "ESC $" and "ESC (" sequences.
+func needsDecoding(line string, iso2022 bool) bool {
+	if !utf8.ValidString(line) {
+		return true
+	}
+	return iso2022 && (strings.Contains(line, "\x1b$") || strings.Contains(line, "\x1b("))
+}
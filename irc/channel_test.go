@@ -0,0 +1,37 @@
+package irc
+
+import (
+	"net"
+	"testing"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+)
+
+func TestChanInOutFallback(t *testing.T) {
+	tests := []struct {
+		fallback encoding.Encoding
+		raw      string
+		expected string
+	}{
+		{charmap.Windows1252, "PRIVMSG #senpai :caf\xe9", "café"},
+		{charmap.Windows1252, "PRIVMSG #senpai :café", "café"},
+		{japanese.ISO2022JP, "PRIVMSG #senpai :\x1b$B$3$s$K$A$O\x1b(B", "こんにちは"},
+		{japanese.ISO2022JP, "PRIVMSG #senpai :hello", "hello"},
+		{charmap.ISO8859_1, "PRIVMSG #senpai :caf\xc3\xa9 \x1b(B", "café \x1b(B"},
+	}
+	for _, test := range tests {
+		client, server := net.Pipe()
+		in, out := ChanInOut(client, test.fallback)
+		go func() {
+			_, _ = server.Write([]byte(test.raw + "\r\n"))
+			_ = server.Close()
+		}()
+		msg := <-in
+		if len(msg.Params) != 2 || msg.Params[1] != test.expected {
+			t.Errorf("%q: expected %q, got %q", test.raw, test.expected, msg.Params)
+		}
+		close(out)
+	}
+}
@@ -12,6 +12,14 @@ type ErrorEvent struct {
 
 type RegisteredEvent struct{}
 
+// LoginEvent is sent when we log in to or out of an account, or when SASL
+// authentication fails.
+type LoginEvent struct {
+	Account string // the account we are logged in as, or "".
+	Failed  bool   // whether authentication failed.
+	Message string // the message of the server, if any.
+}
+
 type SelfNickEvent struct {
 	FormerNick string
 }
@@ -38,12 +46,25 @@ type SelfPartEvent struct {
 
 type UserPartEvent struct {
 	User    string
+	Prefix  *Prefix // the full prefix of the user.
+	Channel string
+	Reason  string
+}
+
+// KickEvent is sent when a user is kicked from a channel.  Kicked may be
+// ourselves, in which case the channel is not joined anymore.
+type KickEvent struct {
+	Kicker  string
+	Kicked  string
 	Channel string
+	Reason  string
 }
 
 type UserQuitEvent struct {
 	User     string
+	Prefix   *Prefix // the full prefix of the user.
 	Channels []string
+	Reason   string
 }
 
 type TopicChangeEvent struct {
@@ -58,14 +79,108 @@ type ModeChangeEvent struct {
 
 type MessageEvent struct {
 	User            string
+	Prefix          *Prefix // the full prefix of the sender.
 	Target          string
 	TargetIsChannel bool
 	Command         string
 	Content         string
 	Time            time.Time
+	MsgID           string // the "msgid" tag, or "" if absent.
+	Bot             bool   // whether the sender is a bot.
+	Tags            Tags
 }
 
 type HistoryEvent struct {
 	Target   string
 	Messages []Event
 }
+
+// ListEntry is an entry of a channel ban, exception or invite-exception list.
+type ListEntry struct {
+	Mask   string
+	Setter string    // who added the entry, or "" if unknown.
+	Time   time.Time // when the entry was added, or the zero time if unknown.
+}
+
+// ChannelListEvent is sent when the server has sent a whole channel list.
+// Mode is 'b' for bans, 'e' for exceptions and 'I' for invite-exceptions.
+type ChannelListEvent struct {
+	Channel string
+	Mode    byte
+	Entries []ListEntry
+}
+
+// ReadEvent is sent when the read marker of a target is updated, for example
+// by another client.
+type ReadEvent struct {
+	Target    string
+	Timestamp time.Time
+}
+
+// BouncerNetworkEvent is sent when a network is added to, changed in or
+// removed from the bouncer.  For changes, Attrs only contains the attributes
+// that changed.
+type BouncerNetworkEvent struct {
+	ID      string
+	Name    string
+	State   string // "connected", "connecting" or "disconnected".
+	Attrs   map[string]string
+	Deleted bool
+}
+
+// MOTDEvent is the message of the day of the server.
+type MOTDEvent struct {
+	Lines     []string // the lines of the MOTD, or nil if there is none.
+	Requested bool     // whether it has been requested, not sent on connection.
+}
+
+// LusersEvent is the reply to LUSERS, with statistics about the network.
+type LusersEvent struct {
+	Client    string // the number of users and servers of the network.
+	Operators int
+	Unknown   int // the number of connections not yet registered.
+	Channels  int
+	Me        string // the number of users and servers of the server.
+	Requested bool   // whether it has been requested, not sent on connection.
+}
+
+// VersionEvent is the reply to VERSION.
+type VersionEvent struct {
+	Version  string
+	Server   string
+	Comments string
+}
+
+// AdminEvent is the reply to ADMIN.
+type AdminEvent struct {
+	Server   string
+	Location []string
+	Email    string
+}
+
+// InfoEvent is the reply to INFO.
+type InfoEvent struct {
+	Lines []string
+}
+
+// UserModeEvent is sent when our user modes are known or change.
+type UserModeEvent struct {
+	Change string // the mode change, or "" when all modes are sent.
+	Modes  string // our user modes after the change, e.g. "iw".
+}
+
+// ServerNoticeEvent is a notice sent by a server, such as the notices of
+// server notice masks (snomasks) sent to operators.
+type ServerNoticeEvent struct {
+	Server   string
+	Category string // the kind of notice, e.g. "Client connecting", or "".
+	Content  string // the notice, without its category.
+	Time     time.Time
+}
+
+// CapabilityEvent is the outcome of RequestCapability.
+type CapabilityEvent struct {
+	Name     string
+	Enabled  bool // whether the capability is now enabled.
+	Rejected bool // whether the server refused the request.
+}
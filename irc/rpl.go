@@ -18,6 +18,7 @@ const (
 	rplAdminloc1     = "257" // :<info>
 	rplAdminloc2     = "258" // :<info>
 	rplAdminmail     = "259" // :<info>
+	rplTryagain      = "263" // <command> :Please wait a while and try again.
 
 	rplAway            = "301" // <nick> :<away message>
 	rplUnaway          = "305" // :You are no longer marked as being away
@@ -35,6 +36,7 @@ const (
 	rplNotopic         = "331" // <channel> :No topic set
 	rplTopic           = "332" // <channel> <topic>
 	rplTopicwhotime    = "333" // <channel> <nick> <setat>
+	rplWhoisbot        = "335" // <nick> :is a bot
 	rplInviting        = "341" // <nick> <channel>
 	rplInvitelist      = "346" // <channel> <invite mask>
 	rplEndofinvitelist = "347" // <channel> :End of invite list
@@ -43,6 +45,7 @@ const (
 	rplVersion         = "351" // <version> <servername> :<comments>
 	rplWhoreply        = "352" // <channel> <user> <host> <server> <nick> "H"/"G" ["*"] [("@"/"+")] :<hop count> <nick>
 	rplNamreply        = "353" // <=/*/@> <channel> :1*(@/ /+user)
+	rplWhospcrpl       = "354" // <token> <user> <host> <nick> <flags> <account> :<realname> (WHOX with %tnuhraf)
 	rplEndofnames      = "366" // <channel> :End of names list
 	rplBanlist         = "367" // <channel> <ban mask>
 	rplEndofbanlist    = "368" // <channel> :End of ban list
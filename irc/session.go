@@ -8,10 +8,12 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 	"unicode"
 	"unicode/utf8"
 
+	"github.com/rivo/uniseg"
 	"golang.org/x/time/rate"
 )
 
@@ -45,6 +47,8 @@ func (auth *SASLPlain) Respond(challenge string) (res string, err error) {
 }
 
 // SupportedCapabilities is the set of capabilities supported by this library.
+// Sessions request all of them, except those disabled by
+// SessionParams.DisabledCaps.
 var SupportedCapabilities = map[string]struct{}{
 	"account-notify":    {},
 	"account-tag":       {},
@@ -52,6 +56,8 @@ var SupportedCapabilities = map[string]struct{}{
 	"batch":             {},
 	"cap-notify":        {},
 	"draft/chathistory": {},
+	"draft/multiline":   {},
+	"draft/read-marker": {},
 	"echo-message":      {},
 	"extended-join":     {},
 	"invite-notify":     {},
@@ -61,7 +67,10 @@ var SupportedCapabilities = map[string]struct{}{
 	"server-time":       {},
 	"sasl":              {},
 	"setname":           {},
-	"userhost-in-names": {},
+
+	"soju.im/bouncer-networks":        {},
+	"soju.im/bouncer-networks-notify": {},
+	"userhost-in-names":               {},
 }
 
 // Values taken by the "@+typing=" client tag.  TypingUnspec means the value or
@@ -75,8 +84,12 @@ const (
 
 // User is a known IRC user (we share a channel with it).
 type User struct {
-	Name    *Prefix // the nick, user and hostname of the user if known.
-	AwayMsg string  // the away message if the user is away, "" otherwise.
+	Name     *Prefix // the nick, user and hostname of the user if known.
+	AwayMsg  string  // the away message if the user is away, "" otherwise.
+	Away     bool    // whether the user is away, even if AwayMsg is unknown.
+	Bot      bool    // whether the user is a bot.
+	Account  string  // the account of the user if known and logged in.
+	RealName string  // the real name of the user if known.
 }
 
 // Channel is a joined channel.
@@ -98,9 +111,21 @@ type SessionParams struct {
 	RealName string
 
 	Auth SASLClient
+
+	// Pass is the server password sent before registration, or "".
+	Pass string
+
+	// DisabledCaps is the list of capabilities that must not be requested.
+	DisabledCaps []string
+
+	// NetID is the ID of the bouncer network the session must be bound to,
+	// or "" for the bouncer itself (or a regular server).
+	NetID string
 }
 
+// Session is an IRC session.  It is safe for concurrent use.
 type Session struct {
+	l            sync.Mutex
 	out          chan<- Message
 	closed       bool
 	registered   bool
@@ -112,11 +137,15 @@ type Session struct {
 	user   string
 	real   string
 	acct   string
+	modes  string // our user modes, without "+".
 	host   string
 	auth   SASLClient
+	netID  string
 
+	wantedCaps    map[string]struct{} // capabilities to request when available.
 	availableCaps map[string]string
 	enabledCaps   map[string]struct{}
+	capReqs       map[string]struct{} // capabilities requested by RequestCapability.
 
 	// ISUPPORT features
 	casemap       func(string) string
@@ -125,13 +154,34 @@ type Session struct {
 	historyLimit  int
 	prefixSymbols string
 	prefixModes   string
-
-	users     map[string]*User        // known users.
-	channels  map[string]Channel      // joined channels.
-	chBatches map[string]HistoryEvent // channel history batches being processed.
-	chReqs    map[string]struct{}     // set of targets for which history is currently requested.
-
-	pendingChannels map[string]time.Time // set of join requests stamps for channels.
+	listModes     string         // type A modes of CHANMODES.
+	botMode       string         // the user mode of bots (BOT ISUPPORT), or "".
+	maxModes      int            // maximum number of modes with a parameter per MODE.
+	targmax       map[string]int // maximum number of targets per command, 0 if unlimited.
+	whox          bool           // whether the server supports WHOX.
+	clientTagDeny []string       // CLIENTTAGDENY: tag names, "*" for all, "-name" for exemptions.
+
+	users     map[string]*User          // known users.
+	channels  map[string]Channel        // joined channels.
+	chBatches map[string]HistoryEvent   // channel history batches being processed.
+	chReqs    map[string]string         // targets for which history is currently requested, by casemapped name.
+	mlBatches map[string]multilineBatch // multiline batches being processed.
+	chLists   map[listKey]channelList   // channel lists being received.
+	batchID   int                       // the ID of the last batch we opened.
+	whoQueue  []string                  // channels to send WHO for, the first one is being received.
+	whoSent   time.Time                 // when the WHO of the first channel of whoQueue was sent.
+
+	// Replies being received.
+	motd   MOTDEvent
+	lusers LusersEvent
+	admin  AdminEvent
+	info   InfoEvent
+
+	motdRemote bool     // whether the MOTD being received is of another server.
+	lastMOTD   []string // the last MOTD of the current server.
+	hasMOTD    bool     // whether lastMOTD has been received.
+
+	pendingChannels map[string]pendingJoin // join requests, by casemapped channel name.
 }
 
 func NewSession(out chan<- Message, params SessionParams) *Session {
@@ -144,29 +194,53 @@ func NewSession(out chan<- Message, params SessionParams) *Session {
 		user:            params.Username,
 		real:            params.RealName,
 		auth:            params.Auth,
+		netID:           params.NetID,
+		wantedCaps:      map[string]struct{}{},
 		availableCaps:   map[string]string{},
 		enabledCaps:     map[string]struct{}{},
+		capReqs:         map[string]struct{}{},
 		casemap:         CasemapRFC1459,
 		chantypes:       "#&",
 		linelen:         512,
 		historyLimit:    100,
 		prefixSymbols:   "@+",
 		prefixModes:     "ov",
+		listModes:       "beI",
+		maxModes:        3,
+		targmax:         map[string]int{},
 		users:           map[string]*User{},
 		channels:        map[string]Channel{},
 		chBatches:       map[string]HistoryEvent{},
-		chReqs:          map[string]struct{}{},
-		pendingChannels: map[string]time.Time{},
+		chReqs:          map[string]string{},
+		mlBatches:       map[string]multilineBatch{},
+		chLists:         map[listKey]channelList{},
+		pendingChannels: map[string]pendingJoin{},
 	}
 
-	s.out <- NewMessage("CAP", "LS", "302")
-	s.out <- NewMessage("NICK", s.nick)
-	s.out <- NewMessage("USER", s.user, "0", "*", s.real)
+	for c := range SupportedCapabilities {
+		s.wantedCaps[c] = struct{}{}
+	}
+	for _, c := range params.DisabledCaps {
+		delete(s.wantedCaps, strings.ToLower(c))
+	}
+
+	if params.Pass != "" {
+		s.send(NewMessage("PASS", params.Pass))
+	}
+	s.send(NewMessage("CAP", "LS", "302"))
+	s.send(NewMessage("NICK", s.nick))
+	s.send(NewMessage("USER", s.user, "0", "*", s.real))
 
 	return s
 }
 
 func (s *Session) Close() {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.close()
+}
+
+func (s *Session) close() {
 	if s.closed {
 		return
 	}
@@ -174,36 +248,158 @@ func (s *Session) Close() {
 	close(s.out)
 }
 
+// send queues msg to be sent to the server.  It is dropped if the session has
+// been closed.
+func (s *Session) send(msg Message) {
+	if s.closed {
+		return
+	}
+	s.out <- msg
+}
+
 // HasCapability reports whether the given capability has been negotiated
 // successfully.
 func (s *Session) HasCapability(capability string) bool {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return s.hasCapability(capability)
+}
+
+func (s *Session) hasCapability(capability string) bool {
 	_, ok := s.enabledCaps[capability]
 	return ok
 }
 
+// Capabilities returns the capabilities advertised by the server, sorted by
+// name, with their values.  Cap.Enable reports whether they are enabled.
+func (s *Session) Capabilities() []Cap {
+	s.l.Lock()
+	defer s.l.Unlock()
+	caps := make([]Cap, 0, len(s.availableCaps))
+	for name, value := range s.availableCaps {
+		_, enabled := s.enabledCaps[name]
+		caps = append(caps, Cap{
+			Name:   name,
+			Value:  value,
+			Enable: enabled,
+		})
+	}
+	sort.Slice(caps, func(i, j int) bool {
+		return caps[i].Name < caps[j].Name
+	})
+	return caps
+}
+
+// RequestCapability requests the server to enable or disable the given
+// capability.  The outcome is sent back as a CapabilityEvent, even before
+// registration ends.  Only capabilities in SupportedCapabilities can be
+// requested.
+func (s *Session) RequestCapability(capability string, enable bool) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	capability = strings.ToLower(capability)
+	if capability == "" {
+		return errors.New("missing capability name")
+	}
+	if _, ok := SupportedCapabilities[capability]; !ok {
+		return fmt.Errorf("capability %s is not supported", capability)
+	}
+	s.capReqs[capability] = struct{}{}
+	if enable {
+		s.wantedCaps[capability] = struct{}{}
+		s.send(NewMessage("CAP", "REQ", capability))
+	} else {
+		delete(s.wantedCaps, capability)
+		s.send(NewMessage("CAP", "REQ", "-"+capability))
+	}
+	return nil
+}
+
 func (s *Session) Nick() string {
+	s.l.Lock()
+	defer s.l.Unlock()
 	return s.nick
 }
 
+// LoggedIn reports whether we are logged in to an account.
+func (s *Session) LoggedIn() bool {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return s.acct != ""
+}
+
+// Account returns the account we are logged in as, or "".
+func (s *Session) Account() string {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return s.acct
+}
+
+// Oper requests IRC operator privileges.
+func (s *Session) Oper(name, password string) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.send(NewMessage("OPER", name, password))
+}
+
+// UserModes returns our user modes, without "+" (e.g. "iw").
+func (s *Session) UserModes() string {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return s.modes
+}
+
+// IdentifyNickServ logs in to the given account by messaging NickServ, for
+// servers that do not support SASL.  Success is reported by a LoginEvent, if
+// the server supports it.
+func (s *Session) IdentifyNickServ(account, password string) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.send(NewMessage("PRIVMSG", "NickServ", "IDENTIFY "+account+" "+password))
+}
+
+// isIdentify reports whether content is a NickServ IDENTIFY command.
+func isIdentify(content string) bool {
+	return strings.HasPrefix(strings.ToUpper(content), "IDENTIFY ")
+}
+
 // NickCf is our casemapped nickname.
 func (s *Session) NickCf() string {
+	s.l.Lock()
+	defer s.l.Unlock()
 	return s.nickCf
 }
 
 func (s *Session) IsMe(nick string) bool {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return s.isMe(nick)
+}
+
+func (s *Session) isMe(nick string) bool {
 	return s.nickCf == s.casemap(nick)
 }
 
 func (s *Session) IsChannel(name string) bool {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return s.isChannel(name)
+}
+
+func (s *Session) isChannel(name string) bool {
 	return strings.IndexAny(name, s.chantypes) == 0
 }
 
 func (s *Session) Casemap(name string) string {
+	s.l.Lock()
+	defer s.l.Unlock()
 	return s.casemap(name)
 }
 
 // Users returns the list of all known nicknames.
 func (s *Session) Users() []string {
+	s.l.Lock()
+	defer s.l.Unlock()
 	users := make([]string, 0, len(s.users))
 	for _, u := range s.users {
 		users = append(users, u.Name.Name)
@@ -215,13 +411,16 @@ func (s *Session) Users() []string {
 // is not known by the session.
 // The list is sorted according to member name.
 func (s *Session) Names(channel string) []Member {
+	s.l.Lock()
+	defer s.l.Unlock()
 	var names []Member
-	if c, ok := s.channels[s.Casemap(channel)]; ok {
+	if c, ok := s.channels[s.casemap(channel)]; ok {
 		names = make([]Member, 0, len(c.Members))
 		for u, pl := range c.Members {
 			names = append(names, Member{
 				PowerLevel: pl,
 				Name:       u.Name.Copy(),
+				Away:       u.Away,
 			})
 		}
 	}
@@ -231,10 +430,24 @@ func (s *Session) Names(channel string) []Member {
 
 // Typings returns the list of nickname who are currently typing.
 func (s *Session) Typings(target string) []string {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return s.typingList(target, false)
+}
+
+// PausedTypings returns the list of nicknames who have stopped typing without
+// clearing their message.
+func (s *Session) PausedTypings(target string) []string {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return s.typingList(target, true)
+}
+
+func (s *Session) typingList(target string, paused bool) []string {
 	targetCf := s.casemap(target)
-	res := s.typings.List(targetCf)
+	res := s.typings.List(targetCf, paused)
 	for i := 0; i < len(res); i++ {
-		if s.IsMe(res[i]) {
+		if s.isMe(res[i]) {
 			res = append(res[:i], res[i+1:]...)
 			i--
 		} else if u, ok := s.users[res[i]]; ok {
@@ -249,9 +462,19 @@ func (s *Session) TypingStops() <-chan Typing {
 	return s.typings.Stops()
 }
 
+// IsJoined reports whether we are a member of the given channel.
+func (s *Session) IsJoined(channel string) bool {
+	s.l.Lock()
+	defer s.l.Unlock()
+	_, ok := s.channels[s.casemap(channel)]
+	return ok
+}
+
 func (s *Session) ChannelsSharedWith(name string) []string {
+	s.l.Lock()
+	defer s.l.Unlock()
 	var user *User
-	if u, ok := s.users[s.Casemap(name)]; ok {
+	if u, ok := s.users[s.casemap(name)]; ok {
 		user = u
 	} else {
 		return nil
@@ -266,7 +489,9 @@ func (s *Session) ChannelsSharedWith(name string) []string {
 }
 
 func (s *Session) Topic(channel string) (topic string, who *Prefix, at time.Time) {
-	channelCf := s.Casemap(channel)
+	s.l.Lock()
+	defer s.l.Unlock()
+	channelCf := s.casemap(channel)
 	if c, ok := s.channels[channelCf]; ok {
 		topic = c.Topic
 		who = c.TopicWho
@@ -275,61 +500,686 @@ func (s *Session) Topic(channel string) (topic string, who *Prefix, at time.Time
 	return
 }
 
+// NetID returns the ID of the bouncer network the session is bound to, or "".
+func (s *Session) NetID() string {
+	return s.netID
+}
+
+// ListNetworks requests the list of networks of the bouncer, which are sent back
+// as BouncerNetworkEvents.
+func (s *Session) ListNetworks() {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.send(NewMessage("BOUNCER", "LISTNETWORKS"))
+}
+
+// MOTD requests the message of the day of the given server, or of the current
+// server if server is "".  It is sent back as a MOTDEvent.
+func (s *Session) MOTD(server string) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.motd.Requested = true
+	s.motdRemote = server != ""
+	s.sendToServer("MOTD", server)
+}
+
+// LastMOTD returns the last message of the day received from the current
+// server, and whether one has been received at all.
+func (s *Session) LastMOTD() (lines []string, ok bool) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return s.lastMOTD, s.hasMOTD
+}
+
+// Lusers requests statistics about the network, which are sent back as a
+// LusersEvent.
+func (s *Session) Lusers() {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.lusers.Requested = true
+	s.send(NewMessage("LUSERS"))
+}
+
+// Version requests the version of the given server, or of the current server
+// if server is "".  It is sent back as a VersionEvent.
+func (s *Session) Version(server string) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.sendToServer("VERSION", server)
+}
+
+// Admin requests the administrative information of the given server, or of the
+// current server if server is "".  It is sent back as an AdminEvent.
+func (s *Session) Admin(server string) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.sendToServer("ADMIN", server)
+}
+
+// Info requests information about the software of the given server, or of the
+// current server if server is "".  It is sent back as an InfoEvent.
+func (s *Session) Info(server string) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.sendToServer("INFO", server)
+}
+
+// sendToServer sends a command with an optional server parameter.
+func (s *Session) sendToServer(command, server string) {
+	if server == "" {
+		s.send(NewMessage(command))
+	} else {
+		s.send(NewMessage(command, server))
+	}
+}
+
+// Bouncer sends a BOUNCER command (such as ADDNETWORK, CHANGENETWORK or
+// DELNETWORK) with the given parameters.
+func (s *Session) Bouncer(subcommand string, params ...string) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	params = append([]string{strings.ToUpper(subcommand)}, params...)
+	s.send(NewMessage("BOUNCER", params...))
+}
+
 func (s *Session) SendRaw(raw string) {
-	s.out <- NewMessage(raw)
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.send(NewMessage(raw))
 }
 
+// Join joins the given channel.  channel and key may also be comma-separated
+// lists of channels and keys.
 func (s *Session) Join(channel, key string) {
-	channelCf := s.Casemap(channel)
-	s.pendingChannels[channelCf] = time.Now()
-	if key == "" {
-		s.out <- NewMessage("JOIN", channel)
-	} else {
-		s.out <- NewMessage("JOIN", channel, key)
+	s.l.Lock()
+	defer s.l.Unlock()
+	var keys []string
+	if key != "" {
+		keys = strings.Split(key, ",")
 	}
+	s.joinMany(strings.Split(channel, ","), keys)
+}
+
+// JoinMany joins the given channels, with the given keys (keys[i] is the key
+// of channels[i], missing or empty keys mean no key), in as few JOIN messages
+// as TARGMAX and the line length allow.
+func (s *Session) JoinMany(channels, keys []string) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.joinMany(channels, keys)
+}
+
+func (s *Session) joinMany(channels, keys []string) {
+	// Keys are positional, so channels with a key go first.
+	var keyed, unkeyed []string
+	var keyList []string
+	for i, channel := range channels {
+		if channel == "" {
+			continue
+		}
+		s.pendingChannels[s.casemap(channel)] = pendingJoin{
+			Channel: channel,
+			At:      time.Now(),
+		}
+		if i < len(keys) && keys[i] != "" {
+			keyed = append(keyed, channel)
+			keyList = append(keyList, keys[i])
+		} else {
+			unkeyed = append(unkeyed, channel)
+		}
+	}
+	channels = append(keyed, unkeyed...)
+
+	maxTargets := s.targmax["JOIN"]
+	maxLen := s.linelen - len("\r\n")
+	var chans, ks []string
+	length := len("JOIN ")
+	flush := func() {
+		if len(chans) == 0 {
+			return
+		}
+		if len(ks) == 0 {
+			s.send(NewMessage("JOIN", strings.Join(chans, ",")))
+		} else {
+			s.send(NewMessage("JOIN", strings.Join(chans, ","), strings.Join(ks, ",")))
+		}
+		chans, ks = chans[:0], ks[:0]
+		length = len("JOIN ")
+	}
+	for i, channel := range channels {
+		added := len(channel) + 1 // with the comma.
+		if i < len(keyList) {
+			added += len(keyList[i]) + 1 // with the comma or space.
+		}
+		if 0 < len(chans) && ((0 < maxTargets && maxTargets <= len(chans)) || maxLen < length+added) {
+			flush()
+		}
+		chans = append(chans, channel)
+		if i < len(keyList) {
+			ks = append(ks, keyList[i])
+		}
+		length += added
+	}
+	flush()
 }
 
 func (s *Session) Part(channel, reason string) {
-	s.out <- NewMessage("PART", channel, reason)
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.send(NewMessage("PART", channel, reason))
 }
 
 func (s *Session) ChangeTopic(channel, topic string) {
-	s.out <- NewMessage("TOPIC", channel, topic)
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.send(NewMessage("TOPIC", channel, topic))
 }
 
 func (s *Session) Quit(reason string) {
-	s.out <- NewMessage("QUIT", reason)
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.send(NewMessage("QUIT", reason))
 }
 
 func (s *Session) ChangeNick(nick string) {
-	s.out <- NewMessage("NICK", nick)
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.send(NewMessage("NICK", nick))
 }
 
 func (s *Session) ChangeMode(channel, flags string, args []string) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.changeMode(channel, flags, args)
+}
+
+func (s *Session) changeMode(channel, flags string, args []string) {
 	args = append([]string{channel, flags}, args...)
-	s.out <- NewMessage("MODE", args...)
+	s.send(NewMessage("MODE", args...))
+}
+
+func (s *Session) Kick(channel, nick, reason string) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if reason == "" {
+		s.send(NewMessage("KICK", channel, nick))
+	} else {
+		s.send(NewMessage("KICK", channel, nick, reason))
+	}
+}
+
+// ChangeMemberModes adds (flag '+') or removes (flag '-') the given mode to the
+// given members or masks of a channel, in as few MODE messages as the MODES
+// ISUPPORT token allows.
+func (s *Session) ChangeMemberModes(channel string, flag byte, mode byte, args []string) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	// Like maxMessageLen, make room for the prefix the server adds when
+	// relaying the message.
+	hostLen := len(s.host)
+	if hostLen == 0 {
+		hostLen = len("255.255.255.255")
+	}
+	maxLen := s.linelen -
+		len(":!@ MODE  +\r\n") -
+		len(s.nick) -
+		len(s.user) -
+		hostLen -
+		len(channel)
+	for 0 < len(args) {
+		n := len(args)
+		if 0 < s.maxModes && s.maxModes < n {
+			n = s.maxModes
+		}
+		// Each argument takes a mode letter, a space and itself.
+		lineLen := 2 + len(args[0])
+		for i := 1; i < n; i++ {
+			lineLen += 2 + len(args[i])
+			if maxLen < lineLen {
+				n = i
+				break
+			}
+		}
+		flags := string(flag) + strings.Repeat(string(mode), n)
+		s.changeMode(channel, flags, args[:n])
+		args = args[n:]
+	}
+}
+
+// HasPrefixMode reports whether the given membership prefix (as given in
+// Member.PowerLevel) includes the given mode, e.g. 'o' for "@".
+func (s *Session) HasPrefixMode(powerLevel string, mode byte) bool {
+	s.l.Lock()
+	defer s.l.Unlock()
+	i := strings.IndexByte(s.prefixModes, mode)
+	if i < 0 || len(s.prefixSymbols) <= i {
+		return false
+	}
+	return strings.IndexByte(powerLevel, s.prefixSymbols[i]) >= 0
+}
+
+// IsBot reports whether the given user is known to be a bot.
+func (s *Session) IsBot(nick string) bool {
+	s.l.Lock()
+	defer s.l.Unlock()
+	u, ok := s.users[s.casemap(nick)]
+	return ok && u.Bot
+}
+
+// SupportsQuiet reports whether the server has a quiet list, set with the 'q'
+// channel mode.
+func (s *Session) SupportsQuiet() bool {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return strings.IndexByte(s.listModes, 'q') >= 0 && strings.IndexByte(s.prefixModes, 'q') < 0
+}
+
+// ChannelList requests a channel list (bans for mode 'b', exceptions for 'e',
+// invite-exceptions for 'I'), which is sent back as a ChannelListEvent.
+func (s *Session) ChannelList(channel string, mode byte) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.send(NewMessage("MODE", channel, "+"+string(mode)))
 }
 
-func splitChunks(s string, chunkLen int) (chunks []string) {
+// BanMask returns a mask that matches the given user, based on its host if it
+// is known.  Masks are returned as is.
+func (s *Session) BanMask(nick string) string {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if strings.ContainsAny(nick, "!@*") {
+		return nick
+	}
+	if u, ok := s.users[s.casemap(nick)]; ok && u.Name.Host != "" {
+		return "*!*@" + u.Name.Host
+	}
+	return nick + "!*@*"
+}
+
+// whoxToken identifies the WHOX replies to the requests of sendWho.
+const whoxToken = "42"
+
+// whoTimeout is the time after which a WHO request that got no reply is given
+// up, so that the channels queued after it are not stalled.
+const whoTimeout = 30 * time.Second
+
+// sendWho sends a WHOX request for the first channel of the queue that is
+// still joined.  Requests are sent one at a time, to avoid flooding when
+// joining many channels.
+func (s *Session) sendWho() {
+	for len(s.whoQueue) != 0 {
+		channel := s.whoQueue[0]
+		if _, ok := s.channels[s.casemap(channel)]; ok {
+			s.send(NewMessage("WHO", channel, "%tnuhraf,"+whoxToken))
+			s.whoSent = time.Now()
+			return
+		}
+		s.whoQueue = s.whoQueue[1:]
+	}
+}
+
+// listKey identifies a channel list being received.
+type listKey struct {
+	channelCf string
+	mode      byte
+}
+
+// channelList is a channel list being received.
+type channelList struct {
+	Channel string // the name of the channel, as sent by the server.
+	Entries []ListEntry
+}
+
+// pendingJoin is a join request that has not been answered yet.
+type pendingJoin struct {
+	Channel string
+	At      time.Time
+}
+
+// listReplies maps list numerics to their mode, and whether they end the list.
+var listReplies = map[string]struct {
+	mode byte
+	end  bool
+}{
+	rplBanlist:         {'b', false},
+	rplEndofbanlist:    {'b', true},
+	rplExceptlist:      {'e', false},
+	rplEndofexceptlist: {'e', true},
+	rplInvitelist:      {'I', false},
+	rplEndofinvitelist: {'I', true},
+}
+
+// handleListReply collects the entries of channel lists, and returns a
+// ChannelListEvent at the end of the list.
+func (s *Session) handleListReply(msg Message) Event {
+	reply := listReplies[msg.Command]
+	if len(msg.Params) < 3 {
+		return nil
+	}
+	channel := msg.Params[1]
+	key := listKey{s.casemap(channel), reply.mode}
+	if reply.end {
+		entries := s.chLists[key].Entries
+		delete(s.chLists, key)
+		if c, ok := s.channels[key.channelCf]; ok {
+			channel = c.Name
+		}
+		return ChannelListEvent{
+			Channel: channel,
+			Mode:    reply.mode,
+			Entries: entries,
+		}
+	}
+	entry := ListEntry{Mask: msg.Params[2]}
+	if len(msg.Params) > 4 {
+		entry.Setter = msg.Params[3]
+		if t, err := strconv.ParseInt(msg.Params[4], 10, 64); err == nil {
+			entry.Time = time.Unix(t, 0)
+		}
+	}
+	list := s.chLists[key]
+	s.chLists[key] = channelList{
+		Channel: channel,
+		Entries: append(list.Entries, entry),
+	}
+	return nil
+}
+
+// splitChunks splits s in chunks of at most chunkLen bytes.  Chunks end at
+// whitespace when possible, and never in the middle of a grapheme cluster
+// (unless a single grapheme cluster is longer than chunkLen).
+//
+// If reformat is true, the formatting (bold, colors...) active at the end of a
+// chunk is applied again at the start of the next one, so that each chunk is
+// rendered as it would have been in a single message.  It must be false when
+// chunks are concatenated back together by the receiver.
+func splitChunks(s string, chunkLen int, reformat bool) (chunks []string) {
 	if chunkLen <= 0 {
 		return []string{s}
 	}
-	for chunkLen < len(s) {
-		i := chunkLen
-		min := chunkLen - utf8.UTFMax
-		for min <= i && !utf8.RuneStart(s[i]) {
-			i--
+	var format formatState
+	prefix := ""
+	for chunkLen < len(prefix)+len(s) {
+		n := chunkLen - len(prefix)
+		if n <= 0 {
+			// The formatting codes alone don't fit; drop them.
+			prefix = ""
+			n = chunkLen
+		}
+		i := chunkCut(s, n)
+		chunks = append(chunks, prefix+s[:i])
+		if reformat {
+			// The prefix only repeats the state, it must not be
+			// applied twice.
+			format.Update(s[:i])
+			prefix = format.String()
 		}
-		chunks = append(chunks, s[:i])
 		s = s[i:]
 	}
 	if len(s) != 0 {
-		chunks = append(chunks, s)
+		chunks = append(chunks, prefix+s)
+	}
+	return
+}
+
+// chunkCut returns the index at which s must be cut for the first part to be
+// at most n bytes long, with 0 < n < len(s).
+func chunkCut(s string, n int) int {
+	cut := 0
+	g := uniseg.NewGraphemes(s)
+	for g.Next() {
+		_, end := g.Positions()
+		if n < end {
+			break
+		}
+		cut = end
+	}
+	if color := strings.LastIndexAny(s[:cut], "\x03\x04"); 0 < color && cut < color+colorLen(s[color:]) {
+		// Do not cut inside a color code, it would change both the
+		// color and the text.
+		cut = color
+	}
+	if cut == 0 {
+		// A single grapheme cluster is longer than n, split it between
+		// two runes.
+		cut = n
+		min := n - utf8.UTFMax
+		for min <= cut && 0 < cut && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		if cut == 0 {
+			cut = n
+		}
+		return cut
+	}
+	if space := strings.LastIndexAny(s[:cut], " \t"); 0 < space {
+		// Keep the whitespace at the end of the chunk, so that the
+		// message is left unchanged by concatenation.
+		cut = space + 1
+	}
+	return cut
+}
+
+// formatState is the formatting (as in <https://modern.ircdocs.horse/formatting.html>)
+// active at some point of a message.
+type formatState struct {
+	bold, italic, underline, strikethrough, monospace, reverse bool
+
+	color string // the last color code, including its 0x03 or 0x04 byte, with two-digit colors.
+}
+
+// Update changes the state according to the formatting codes in s.
+func (f *formatState) Update(s string) {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case 0x02:
+			f.bold = !f.bold
+		case 0x1D:
+			f.italic = !f.italic
+		case 0x1F:
+			f.underline = !f.underline
+		case 0x1E:
+			f.strikethrough = !f.strikethrough
+		case 0x11:
+			f.monospace = !f.monospace
+		case 0x16:
+			f.reverse = !f.reverse
+		case 0x0F:
+			*f = formatState{}
+		case 0x03, 0x04:
+			j := i + colorLen(s[i:])
+			if j == i+1 {
+				f.color = ""
+			} else if s[i] == 0x03 {
+				// Colors are written with two digits, so that they
+				// do not run into the digits that follow them.
+				fg := strings.SplitN(s[i+1:j], ",", 2)
+				f.color = "\x03" + twoDigits(fg[0])
+				if len(fg) == 2 {
+					f.color += "," + twoDigits(fg[1])
+				}
+			} else {
+				f.color = s[i:j]
+			}
+			i = j - 1
+		}
+	}
+}
+
+// colorLen returns the length of the color code at the start of s, including
+// its 0x03 or 0x04 byte.
+func colorLen(s string) int {
+	isDigit := func(c byte) bool { return '0' <= c && c <= '9' }
+	maxLen := 2
+	if s[0] == 0x04 {
+		isDigit = func(c byte) bool {
+			return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+		}
+		maxLen = 6
+	}
+	j := 1
+	for j < len(s) && j-1 < maxLen && isDigit(s[j]) {
+		j++
+	}
+	if j != 1 && j+1 < len(s) && s[j] == ',' && isDigit(s[j+1]) {
+		k := j + 1
+		for k < len(s) && k-j-1 < maxLen && isDigit(s[k]) {
+			k++
+		}
+		j = k
+	}
+	return j
+}
+
+// twoDigits pads one-digit colors with a zero.
+func twoDigits(color string) string {
+	if len(color) == 1 {
+		return "0" + color
+	}
+	return color
+}
+
+// String returns the formatting codes needed to apply the state to the start
+// of a message.
+func (f *formatState) String() string {
+	var sb strings.Builder
+	if f.bold {
+		sb.WriteByte(0x02)
+	}
+	if f.italic {
+		sb.WriteByte(0x1D)
+	}
+	if f.underline {
+		sb.WriteByte(0x1F)
+	}
+	if f.strikethrough {
+		sb.WriteByte(0x1E)
+	}
+	if f.monospace {
+		sb.WriteByte(0x11)
+	}
+	if f.reverse {
+		sb.WriteByte(0x16)
+	}
+	sb.WriteString(f.color)
+	return sb.String()
+}
+
+// multilineBatch is an incoming draft/multiline batch.
+type multilineBatch struct {
+	Parent string            // the ID of the enclosing chathistory batch, if any.
+	Tags   map[string]string // the tags of the batch, such as msgid.
+	Event  MessageEvent      // the concatenation of the messages received so far.
+	Empty  bool              // whether no message has been received yet.
+}
+
+// multilineLimits returns the max-bytes and max-lines values of the
+// draft/multiline capability.  maxLines is 0 if there is no limit.
+func (s *Session) multilineLimits() (maxBytes, maxLines int) {
+	for _, kv := range strings.Split(s.availableCaps["draft/multiline"], ",") {
+		kv := strings.SplitN(kv, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		n, err := strconv.Atoi(kv[1])
+		if err != nil {
+			continue
+		}
+		switch kv[0] {
+		case "max-bytes":
+			maxBytes = n
+		case "max-lines":
+			maxLines = n
+		}
+	}
+	if maxBytes <= 0 {
+		maxBytes = s.linelen
 	}
 	return
 }
 
+// PrivMsg sends content to target.  Long messages are split in several
+// PRIVMSGs.  If content contains several lines, they are sent in a
+// draft/multiline batch when the server supports it, or one PRIVMSG per line
+// otherwise.
 func (s *Session) PrivMsg(target, content string) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	lines := strings.Split(content, "\n")
+	if 1 < len(lines) && s.hasCapability("batch") && s.hasCapability("draft/multiline") {
+		s.privMsgMultiline(target, lines)
+	} else {
+		maxMessageLen := s.maxMessageLen(target, nil)
+		for _, line := range lines {
+			chunks := splitChunks(line, maxMessageLen, true)
+			for _, chunk := range chunks {
+				s.send(NewMessage("PRIVMSG", target, chunk))
+			}
+		}
+	}
+	targetCf := s.casemap(target)
+	delete(s.typingStamps, targetCf)
+}
+
+// privMsgMultiline sends the given lines in as few draft/multiline batches as
+// the limits of the server allow.  Lines too long for a single PRIVMSG are
+// split and joined back with the draft/multiline-concat tag.
+func (s *Session) privMsgMultiline(target string, lines []string) {
+	maxMessageLen := s.maxMessageLen(target, map[string]string{
+		"batch":                  strconv.Itoa(s.batchID + len(lines)),
+		"draft/multiline-concat": "",
+	})
+	maxBytes, maxLines := s.multilineLimits()
+	if maxBytes < maxMessageLen {
+		maxMessageLen = maxBytes
+	}
+
+	var batch []Message
+	batchBytes := 0
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		s.batchID++
+		id := strconv.Itoa(s.batchID)
+		s.send(NewMessage("BATCH", "+"+id, "draft/multiline", target))
+		for _, msg := range batch {
+			s.send(msg.WithTag("batch", id))
+		}
+		s.send(NewMessage("BATCH", "-"+id))
+		batch = batch[:0]
+		batchBytes = 0
+	}
+
+	for _, line := range lines {
+		chunks := splitChunks(line, maxMessageLen, false)
+		if len(chunks) == 0 {
+			chunks = []string{""}
+		}
+		for i, chunk := range chunks {
+			size := len(chunk)
+			if i == 0 && len(batch) != 0 {
+				size++ // the line separator.
+			}
+			if (maxLines != 0 && maxLines <= len(batch)) || maxBytes < batchBytes+size {
+				flush()
+				size = len(chunk)
+			}
+			msg := NewMessage("PRIVMSG", target, chunk)
+			if i != 0 && len(batch) != 0 {
+				msg = msg.WithTag("draft/multiline-concat", "")
+			}
+			batch = append(batch, msg)
+			batchBytes += size
+		}
+	}
+	flush()
+}
+
+// maxMessageLen returns the maximum length of the content of a PRIVMSG sent to
+// target with the given tags, so that the message relayed by the server fits in
+// a line.  Tags are counted in the line length, since some servers do.
+func (s *Session) maxMessageLen(target string, tags map[string]string) int {
 	hostLen := len(s.host)
 	if hostLen == 0 {
 		hostLen = len("255.255.255.255")
@@ -340,16 +1190,49 @@ func (s *Session) PrivMsg(target, content string) {
 		len(s.user) -
 		hostLen -
 		len(target)
-	chunks := splitChunks(content, maxMessageLen)
-	for _, chunk := range chunks {
-		s.out <- NewMessage("PRIVMSG", target, chunk)
+	if len(tags) != 0 {
+		maxMessageLen -= len("@ ")
+		for k, v := range tags {
+			maxMessageLen -= len(k) + len(";")
+			if v != "" {
+				maxMessageLen -= len("=") + len(escapeTagValue(v))
+			}
+		}
 	}
-	targetCf := s.Casemap(target)
-	delete(s.typingStamps, targetCf)
+	return maxMessageLen
+}
+
+// ClientTagAllowed reports whether the server accepts the given client tag
+// (e.g. "+typing"), according to its CLIENTTAGDENY.
+func (s *Session) ClientTagAllowed(tag string) bool {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return s.clientTagAllowed(tag)
+}
+
+func (s *Session) clientTagAllowed(tag string) bool {
+	if !s.hasCapability("message-tags") {
+		return false
+	}
+	name := strings.TrimPrefix(tag, "+")
+	denyAll := false
+	for _, d := range s.clientTagDeny {
+		switch d {
+		case name:
+			return false
+		case "-" + name:
+			return true
+		case "*":
+			denyAll = true
+		}
+	}
+	return !denyAll
 }
 
 func (s *Session) Typing(target string) {
-	if !s.HasCapability("message-tags") {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if !s.clientTagAllowed("+typing") {
 		return
 	}
 	targetCf := s.casemap(target)
@@ -363,15 +1246,40 @@ func (s *Session) Typing(target string) {
 		t.Limit.Reserve() // will always be OK
 	}
 	s.typingStamps[targetCf] = typingStamp{
-		Last:  now,
-		Type:  TypingActive,
-		Limit: t.Limit,
+		Target: target,
+		Last:   now,
+		Type:   TypingActive,
+		Limit:  t.Limit,
 	}
-	s.out <- NewMessage("TAGMSG", target).WithTag("+typing", "active")
+	s.send(NewMessage("TAGMSG", target).WithTag("+typing", "active"))
+}
+
+// TypingPause tells target that we have stopped typing, without clearing our
+// message.  It is only sent after Typing.
+func (s *Session) TypingPause(target string) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if !s.clientTagAllowed("+typing") {
+		return
+	}
+	targetCf := s.casemap(target)
+	t, ok := s.typingStamps[targetCf]
+	if !ok || t.Type != TypingActive || !t.Limit.Allow() {
+		return
+	}
+	s.typingStamps[targetCf] = typingStamp{
+		Target: target,
+		Last:   time.Now(),
+		Type:   TypingPaused,
+		Limit:  t.Limit,
+	}
+	s.send(NewMessage("TAGMSG", target).WithTag("+typing", "paused"))
 }
 
 func (s *Session) TypingStop(target string) {
-	if !s.HasCapability("message-tags") {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if !s.clientTagAllowed("+typing") {
 		return
 	}
 	targetCf := s.casemap(target)
@@ -386,11 +1294,12 @@ func (s *Session) TypingStop(target string) {
 		t.Limit.Reserve() // will always be OK
 	}
 	s.typingStamps[targetCf] = typingStamp{
-		Last:  now,
-		Type:  TypingDone,
-		Limit: t.Limit,
+		Target: target,
+		Last:   now,
+		Type:   TypingDone,
+		Limit:  t.Limit,
 	}
-	s.out <- NewMessage("TAGMSG", target).WithTag("+typing", "done")
+	s.send(NewMessage("TAGMSG", target).WithTag("+typing", "done"))
 }
 
 type HistoryRequest struct {
@@ -407,6 +1316,8 @@ func formatTimestamp(t time.Time) string {
 }
 
 func (r *HistoryRequest) WithLimit(limit int) *HistoryRequest {
+	r.s.l.Lock()
+	defer r.s.l.Unlock()
 	if limit < r.s.historyLimit {
 		r.limit = limit
 	} else {
@@ -416,7 +1327,9 @@ func (r *HistoryRequest) WithLimit(limit int) *HistoryRequest {
 }
 
 func (r *HistoryRequest) doRequest() {
-	if !r.s.HasCapability("draft/chathistory") {
+	r.s.l.Lock()
+	defer r.s.l.Unlock()
+	if !r.s.hasCapability("draft/chathistory") {
 		return
 	}
 
@@ -424,14 +1337,14 @@ func (r *HistoryRequest) doRequest() {
 	if _, ok := r.s.chReqs[targetCf]; ok {
 		return
 	}
-	r.s.chReqs[targetCf] = struct{}{}
+	r.s.chReqs[targetCf] = r.target
 
 	args := make([]string, 0, len(r.bounds)+3)
 	args = append(args, r.command)
 	args = append(args, r.target)
 	args = append(args, r.bounds...)
 	args = append(args, strconv.Itoa(r.limit))
-	r.s.out <- NewMessage("CHATHISTORY", args...)
+	r.s.send(NewMessage("CHATHISTORY", args...))
 }
 
 func (r *HistoryRequest) After(t time.Time) {
@@ -446,7 +1359,20 @@ func (r *HistoryRequest) Before(t time.Time) {
 	r.doRequest()
 }
 
+// MarkRead sets the read marker of target to the given time, so that other
+// clients know its messages have been read.
+func (s *Session) MarkRead(target string, t time.Time) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if !s.hasCapability("draft/read-marker") {
+		return
+	}
+	s.send(NewMessage("MARKREAD", target, formatTimestamp(t.UTC())))
+}
+
 func (s *Session) NewHistoryRequest(target string) *HistoryRequest {
+	s.l.Lock()
+	defer s.l.Unlock()
 	return &HistoryRequest{
 		s:      s,
 		target: target,
@@ -455,6 +1381,8 @@ func (s *Session) NewHistoryRequest(target string) *HistoryRequest {
 }
 
 func (s *Session) HandleMessage(msg Message) Event {
+	s.l.Lock()
+	defer s.l.Unlock()
 	if s.registered {
 		return s.handleRegistered(msg)
 	} else {
@@ -462,23 +1390,20 @@ func (s *Session) HandleMessage(msg Message) Event {
 	}
 }
 
+// endRegistration ends capability negotiation, after binding the connection to
+// its bouncer network if needed.
+func (s *Session) endRegistration() {
+	if s.netID != "" {
+		s.send(NewMessage("BOUNCER", "BIND", s.netID))
+	}
+	s.send(NewMessage("CAP", "END"))
+}
+
 func (s *Session) handleUnregistered(msg Message) Event {
 	switch msg.Command {
-	case "AUTHENTICATE":
-		if s.auth != nil {
-			res, err := s.auth.Respond(msg.Params[0])
-			if err != nil {
-				s.out <- NewMessage("AUTHENTICATE", "*")
-			} else {
-				s.out <- NewMessage("AUTHENTICATE", res)
-			}
-		}
-	case rplLoggedin:
-		s.out <- NewMessage("CAP", "END")
-		s.acct = msg.Params[2]
-		s.host = ParsePrefix(msg.Params[1]).Host
-	case errNicklocked, errSaslfail, errSasltoolong, errSaslaborted, errSaslalready, rplSaslmechs:
-		s.out <- NewMessage("CAP", "END")
+	case rplLoggedin, errNicklocked, errSaslfail, errSasltoolong, errSaslaborted, errSaslalready, rplSaslmechs:
+		s.endRegistration()
+		return s.handleRegistered(msg)
 	case "CAP":
 		switch msg.Params[1] {
 		case "LS":
@@ -499,24 +1424,25 @@ func (s *Session) handleUnregistered(msg Message) Event {
 
 			if !willContinue {
 				for c := range s.availableCaps {
-					if _, ok := SupportedCapabilities[c]; !ok {
+					if _, ok := s.wantedCaps[c]; !ok {
 						continue
 					}
-					s.out <- NewMessage("CAP", "REQ", c)
+					s.send(NewMessage("CAP", "REQ", c))
 				}
 
-				_, ok := s.availableCaps["sasl"]
-				if s.auth == nil || !ok {
-					s.out <- NewMessage("CAP", "END")
+				_, available := s.availableCaps["sasl"]
+				_, wanted := s.wantedCaps["sasl"]
+				if s.auth == nil || !available || !wanted {
+					s.endRegistration()
 				}
 			}
 		default:
+			// ACK and NAK, including the replies to
+			// RequestCapability, are handled the same way.
 			return s.handleRegistered(msg)
 		}
 	case errNicknameinuse:
-		s.out <- NewMessage("NICK", msg.Params[1]+"_")
-	case rplSaslsuccess:
-		// do nothing
+		s.send(NewMessage("NICK", msg.Params[1]+"_"))
 	default:
 		return s.handleRegistered(msg)
 	}
@@ -525,7 +1451,29 @@ func (s *Session) handleUnregistered(msg Message) Event {
 
 func (s *Session) handleRegistered(msg Message) Event {
 	if id, ok := msg.Tags["batch"]; ok {
-		if b, ok := s.chBatches[id]; ok {
+		if b, ok := s.mlBatches[id]; ok {
+			if msg.Command == "PRIVMSG" || msg.Command == "NOTICE" {
+				ev := s.newMessageEvent(msg)
+				if b.Empty {
+					// The batch is the message: it holds its
+					// msgid and time.
+					ev.Tags = NewTags(b.Tags)
+					ev.MsgID = b.Tags["msgid"]
+					if t, ok := parseTimestamp(b.Tags["time"]); ok {
+						ev.Time = t
+					}
+					b.Event = ev
+					b.Empty = false
+				} else if _, ok := msg.Tags["draft/multiline-concat"]; ok {
+					b.Event.Content += ev.Content
+				} else {
+					b.Event.Content += "\n" + ev.Content
+				}
+				s.mlBatches[id] = b
+			}
+			return nil
+		}
+		if b, ok := s.chBatches[id]; ok && msg.Command != "BATCH" {
 			ev := s.newMessageEvent(msg)
 			s.chBatches[id] = HistoryEvent{
 				Target:   b.Target,
@@ -538,57 +1486,171 @@ func (s *Session) handleRegistered(msg Message) Event {
 	switch msg.Command {
 	case rplWelcome:
 		s.nick = msg.Params[0]
-		s.nickCf = s.Casemap(s.nick)
+		s.nickCf = s.casemap(s.nick)
 		s.registered = true
 		s.users[s.nickCf] = &User{Name: &Prefix{
 			Name: s.nick, User: s.user, Host: s.host,
 		}}
 		if s.host == "" {
-			s.out <- NewMessage("WHO", s.nick)
+			s.send(NewMessage("WHO", s.nick))
 		}
 		return RegisteredEvent{}
 	case rplIsupport:
 		s.updateFeatures(msg.Params[1 : len(msg.Params)-1])
 	case rplWhoreply:
-		if s.nickCf == s.Casemap(msg.Params[5]) {
+		if s.nickCf == s.casemap(msg.Params[5]) {
 			s.host = msg.Params[3]
 		}
+		if u, ok := s.users[s.casemap(msg.Params[5])]; ok && s.botMode != "" {
+			u.Bot = strings.Contains(msg.Params[6], s.botMode)
+		}
+	case rplWhospcrpl:
+		if msg.Params[1] != whoxToken {
+			break
+		}
+		u, ok := s.users[s.casemap(msg.Params[4])]
+		if !ok {
+			break
+		}
+		u.Name.User = msg.Params[2]
+		u.Name.Host = msg.Params[3]
+		u.Away = strings.HasPrefix(msg.Params[5], "G")
+		if s.botMode != "" {
+			u.Bot = strings.Contains(msg.Params[5], s.botMode)
+		}
+		if msg.Params[6] == "0" {
+			u.Account = ""
+		} else {
+			u.Account = msg.Params[6]
+		}
+		u.RealName = msg.Params[7]
+	case rplEndofwho, errNosuchchannel:
+		if len(s.whoQueue) != 0 && s.casemap(msg.Params[1]) == s.casemap(s.whoQueue[0]) {
+			s.whoQueue = s.whoQueue[1:]
+			s.sendWho()
+		}
+	case errUnknowncommand, rplTryagain:
+		if msg.Params[1] == "WHO" && len(s.whoQueue) != 0 {
+			// The request failed, go on with the next channel.
+			s.whoQueue = s.whoQueue[1:]
+			s.sendWho()
+		}
+	case rplMotdstart:
+		s.motd.Lines = nil
+	case rplMotd:
+		line := strings.TrimPrefix(msg.Params[1], "-")
+		s.motd.Lines = append(s.motd.Lines, strings.TrimPrefix(line, " "))
+	case rplEndofmotd, errNomotd:
+		ev := s.motd
+		if !s.motdRemote {
+			s.lastMOTD = ev.Lines
+			s.hasMOTD = true
+		}
+		s.motd = MOTDEvent{}
+		s.motdRemote = false
+		return ev
+	case rplLuserclient:
+		s.lusers.Client = msg.Params[1]
+	case rplLuserop:
+		s.lusers.Operators, _ = strconv.Atoi(msg.Params[1])
+	case rplLuserunknown:
+		s.lusers.Unknown, _ = strconv.Atoi(msg.Params[1])
+	case rplLuserchannels:
+		s.lusers.Channels, _ = strconv.Atoi(msg.Params[1])
+	case rplLuserme:
+		ev := s.lusers
+		ev.Me = msg.Params[1]
+		s.lusers = LusersEvent{}
+		return ev
+	case rplVersion:
+		ev := VersionEvent{
+			Version: msg.Params[1],
+			Server:  msg.Params[2],
+		}
+		if 3 < len(msg.Params) {
+			ev.Comments = msg.Params[3]
+		}
+		return ev
+	case rplAdminme:
+		s.admin = AdminEvent{Server: msg.Params[1]}
+	case rplAdminloc1, rplAdminloc2:
+		if msg.Params[1] != "" {
+			s.admin.Location = append(s.admin.Location, msg.Params[1])
+		}
+	case rplAdminmail:
+		ev := s.admin
+		ev.Email = msg.Params[1]
+		s.admin = AdminEvent{}
+		return ev
+	case rplInfo:
+		s.info.Lines = append(s.info.Lines, msg.Params[1])
+	case rplEndofinfo:
+		ev := s.info
+		s.info = InfoEvent{}
+		return ev
+	case rplWhoisbot:
+		if u, ok := s.users[s.casemap(msg.Params[1])]; ok {
+			u.Bot = true
+		}
 	case "CAP":
 		switch msg.Params[1] {
 		case "ACK":
+			var ev Event
 			for _, c := range ParseCaps(msg.Params[2]) {
+				if _, ok := s.capReqs[c.Name]; ok {
+					delete(s.capReqs, c.Name)
+					ev = CapabilityEvent{
+						Name:    c.Name,
+						Enabled: c.Enable,
+					}
+				}
 				if c.Enable {
 					s.enabledCaps[c.Name] = struct{}{}
 				} else {
 					delete(s.enabledCaps, c.Name)
 				}
 
-				if s.auth != nil && c.Name == "sasl" {
+				if s.auth != nil && c.Name == "sasl" && c.Enable && s.acct == "" {
 					h := s.auth.Handshake()
-					s.out <- NewMessage("AUTHENTICATE", h)
+					s.send(NewMessage("AUTHENTICATE", h))
 				} else if len(s.channels) != 0 && c.Name == "multi-prefix" {
 					// TODO merge NAMES commands
 					for channel := range s.channels {
-						s.out <- NewMessage("NAMES", channel)
+						s.send(NewMessage("NAMES", channel))
 					}
 				}
 			}
+			return ev
 		case "NAK":
-			// do nothing
+			var ev Event
+			for _, c := range ParseCaps(msg.Params[2]) {
+				if _, ok := s.capReqs[c.Name]; ok {
+					delete(s.capReqs, c.Name)
+					ev = CapabilityEvent{
+						Name:     c.Name,
+						Enabled:  s.hasCapability(c.Name),
+						Rejected: true,
+					}
+				}
+				if !s.registered && s.auth != nil && c.Name == "sasl" {
+					// Registration was waiting for the outcome of
+					// SASL authentication.
+					s.endRegistration()
+				}
+			}
+			return ev
 		case "NEW":
 			for _, c := range ParseCaps(msg.Params[2]) {
 				s.availableCaps[c.Name] = c.Value
-				_, ok := SupportedCapabilities[c.Name]
+				_, ok := s.wantedCaps[c.Name]
 				if !ok {
 					continue
 				}
-				s.out <- NewMessage("CAP", "REQ", c.Name)
+				s.send(NewMessage("CAP", "REQ", c.Name))
 			}
 
-			_, ok := s.availableCaps["sasl"]
-			if s.acct == "" && ok {
-				// TODO authenticate
-			}
+			// SASL authentication starts once "sasl" is
+			// acknowledged, if we are not logged in yet.
 		case "DEL":
 			for _, c := range ParseCaps(msg.Params[2]) {
 				delete(s.availableCaps, c.Name)
@@ -596,9 +1658,9 @@ func (s *Session) handleRegistered(msg Message) Event {
 			}
 		}
 	case "JOIN":
-		nickCf := s.Casemap(msg.Prefix.Name)
-		channelCf := s.Casemap(msg.Params[0])
-		if s.IsMe(msg.Prefix.Name) {
+		nickCf := s.casemap(msg.Prefix.Name)
+		channelCf := s.casemap(msg.Params[0])
+		if s.isMe(msg.Prefix.Name) {
 			s.channels[channelCf] = Channel{
 				Name:    msg.Params[0],
 				Members: map[*User]string{},
@@ -614,9 +1676,9 @@ func (s *Session) handleRegistered(msg Message) Event {
 			}
 		}
 	case "PART":
-		nickCf := s.Casemap(msg.Prefix.Name)
-		channelCf := s.Casemap(msg.Params[0])
-		if s.IsMe(msg.Prefix.Name) {
+		nickCf := s.casemap(msg.Prefix.Name)
+		channelCf := s.casemap(msg.Params[0])
+		if s.isMe(msg.Prefix.Name) {
 			if c, ok := s.channels[channelCf]; ok {
 				delete(s.channels, channelCf)
 				for u := range c.Members {
@@ -631,38 +1693,51 @@ func (s *Session) handleRegistered(msg Message) Event {
 				delete(c.Members, u)
 				s.cleanUser(u)
 				s.typings.Done(channelCf, nickCf)
-				return UserPartEvent{
+				ev := UserPartEvent{
 					User:    u.Name.Name,
+					Prefix:  msg.Prefix.Copy(),
 					Channel: c.Name,
 				}
+				if len(msg.Params) > 1 {
+					ev.Reason = msg.Params[1]
+				}
+				return ev
 			}
 		}
 	case "KICK":
-		nickCf := s.Casemap(msg.Params[1])
-		channelCf := s.Casemap(msg.Params[0])
-		if s.IsMe(msg.Prefix.Name) {
-			if c, ok := s.channels[channelCf]; ok {
-				delete(s.channels, channelCf)
-				for u := range c.Members {
-					s.cleanUser(u)
-				}
-				return SelfPartEvent{
-					Channel: c.Name,
-				}
-			}
-		} else if c, ok := s.channels[channelCf]; ok {
-			if u, ok := s.users[nickCf]; ok {
-				delete(c.Members, u)
+		if len(msg.Params) < 2 {
+			break
+		}
+		nickCf := s.casemap(msg.Params[1])
+		channelCf := s.casemap(msg.Params[0])
+		c, ok := s.channels[channelCf]
+		if !ok {
+			break
+		}
+		ev := KickEvent{
+			Kicker:  msg.Prefix.Name,
+			Kicked:  msg.Params[1],
+			Channel: c.Name,
+		}
+		if len(msg.Params) > 2 {
+			ev.Reason = msg.Params[2]
+		}
+		if s.isMe(msg.Params[1]) {
+			delete(s.channels, channelCf)
+			for u := range c.Members {
 				s.cleanUser(u)
-				s.typings.Done(channelCf, nickCf)
-				return UserPartEvent{
-					User:    u.Name.Name,
-					Channel: c.Name,
-				}
 			}
+			return ev
+		}
+		if u, ok := s.users[nickCf]; ok {
+			ev.Kicked = u.Name.Name
+			delete(c.Members, u)
+			s.cleanUser(u)
+			s.typings.Done(channelCf, nickCf)
+			return ev
 		}
 	case "QUIT":
-		nickCf := s.Casemap(msg.Prefix.Name)
+		nickCf := s.casemap(msg.Prefix.Name)
 
 		if u, ok := s.users[nickCf]; ok {
 			var channels []string
@@ -674,19 +1749,24 @@ func (s *Session) handleRegistered(msg Message) Event {
 					s.typings.Done(channelCf, nickCf)
 				}
 			}
-			return UserQuitEvent{
+			ev := UserQuitEvent{
 				User:     u.Name.Name,
+				Prefix:   msg.Prefix.Copy(),
 				Channels: channels,
 			}
+			if len(msg.Params) > 0 {
+				ev.Reason = msg.Params[0]
+			}
+			return ev
 		}
 	case rplNamreply:
-		channelCf := s.Casemap(msg.Params[2])
+		channelCf := s.casemap(msg.Params[2])
 
 		if c, ok := s.channels[channelCf]; ok {
 			c.Secret = msg.Params[1] == "@"
 
 			for _, name := range ParseNameReply(msg.Params[3], s.prefixSymbols) {
-				nickCf := s.Casemap(name.Name.Name)
+				nickCf := s.casemap(name.Name.Name)
 
 				if _, ok := s.users[nickCf]; !ok {
 					s.users[nickCf] = &User{Name: name.Name.Copy()}
@@ -697,7 +1777,7 @@ func (s *Session) handleRegistered(msg Message) Event {
 			s.channels[channelCf] = c
 		}
 	case rplEndofnames:
-		channelCf := s.Casemap(msg.Params[1])
+		channelCf := s.casemap(msg.Params[1])
 		if c, ok := s.channels[channelCf]; ok && !c.complete {
 			c.complete = true
 			s.channels[channelCf] = c
@@ -705,19 +1785,32 @@ func (s *Session) handleRegistered(msg Message) Event {
 				Channel: c.Name,
 				Topic:   c.Topic,
 			}
-			if stamp, ok := s.pendingChannels[channelCf]; ok && time.Now().Sub(stamp) < 5*time.Second {
+			if p, ok := s.pendingChannels[channelCf]; ok && time.Now().Sub(p.At) < 5*time.Second {
 				ev.Requested = true
 			}
+			if s.whox {
+				s.whoQueue = append(s.whoQueue, c.Name)
+				if len(s.whoQueue) == 1 {
+					s.sendWho()
+				} else if whoTimeout < time.Since(s.whoSent) {
+					// The server never answered the request
+					// being received.
+					s.whoQueue = s.whoQueue[1:]
+					s.sendWho()
+				}
+			}
 			return ev
 		}
+	case rplBanlist, rplEndofbanlist, rplExceptlist, rplEndofexceptlist, rplInvitelist, rplEndofinvitelist:
+		return s.handleListReply(msg)
 	case rplTopic:
-		channelCf := s.Casemap(msg.Params[1])
+		channelCf := s.casemap(msg.Params[1])
 		if c, ok := s.channels[channelCf]; ok {
 			c.Topic = msg.Params[2]
 			s.channels[channelCf] = c
 		}
 	case rplTopicwhotime:
-		channelCf := s.Casemap(msg.Params[1])
+		channelCf := s.casemap(msg.Params[1])
 		t, _ := strconv.ParseInt(msg.Params[3], 10, 64)
 		if c, ok := s.channels[channelCf]; ok {
 			c.TopicWho = ParsePrefix(msg.Params[2])
@@ -725,13 +1818,13 @@ func (s *Session) handleRegistered(msg Message) Event {
 			s.channels[channelCf] = c
 		}
 	case rplNotopic:
-		channelCf := s.Casemap(msg.Params[1])
+		channelCf := s.casemap(msg.Params[1])
 		if c, ok := s.channels[channelCf]; ok {
 			c.Topic = ""
 			s.channels[channelCf] = c
 		}
 	case "TOPIC":
-		channelCf := s.Casemap(msg.Params[0])
+		channelCf := s.casemap(msg.Params[0])
 		if c, ok := s.channels[channelCf]; ok {
 			c.Topic = msg.Params[1]
 			c.TopicWho = msg.Prefix.Copy()
@@ -742,24 +1835,67 @@ func (s *Session) handleRegistered(msg Message) Event {
 				Topic:   c.Topic,
 			}
 		}
+	case rplUmodeis:
+		s.modes = strings.TrimPrefix(msg.Params[1], "+")
+		return UserModeEvent{
+			Modes: s.modes,
+		}
 	case "MODE":
-		channelCf := s.Casemap(msg.Params[0])
+		if s.isMe(msg.Params[0]) && 2 <= len(msg.Params) {
+			s.applyUserModes(msg.Params[1])
+			return UserModeEvent{
+				Change: msg.Params[1],
+				Modes:  s.modes,
+			}
+		}
+		channelCf := s.casemap(msg.Params[0])
 		if c, ok := s.channels[channelCf]; ok {
 			return ModeChangeEvent{
 				Channel: c.Name,
-				Mode: strings.Join(msg.Params[1:], " "),
+				Mode:    strings.Join(msg.Params[1:], " "),
 			}
 		}
+	case "AWAY":
+		if u, ok := s.users[s.casemap(msg.Prefix.Name)]; ok {
+			if len(msg.Params) != 0 && msg.Params[0] != "" {
+				u.Away = true
+				u.AwayMsg = msg.Params[0]
+			} else {
+				u.Away = false
+				u.AwayMsg = ""
+			}
+		}
+	case "ACCOUNT":
+		account := msg.Params[0]
+		if account == "*" {
+			account = ""
+		}
+		if s.isMe(msg.Prefix.Name) {
+			s.acct = account
+			return LoginEvent{
+				Account: account,
+			}
+		}
+		if u, ok := s.users[s.casemap(msg.Prefix.Name)]; ok {
+			u.Account = account
+		}
 	case "PRIVMSG", "NOTICE":
+		if msg.Command == "NOTICE" && isServerPrefix(msg.Prefix) && !s.isChannel(msg.Params[0]) {
+			return s.newServerNoticeEvent(msg)
+		}
 		targetCf := s.casemap(msg.Params[0])
 		nickCf := s.casemap(msg.Prefix.Name)
+		if s.isMe(msg.Prefix.Name) && targetCf == s.casemap("NickServ") && isIdentify(msg.Params[1]) {
+			// Do not show the password sent by IdentifyNickServ.
+			break
+		}
 		s.typings.Done(targetCf, nickCf)
 		return s.newMessageEvent(msg)
 	case "TAGMSG":
-		nickCf := s.Casemap(msg.Prefix.Name)
-		targetCf := s.Casemap(msg.Params[0])
+		nickCf := s.casemap(msg.Prefix.Name)
+		targetCf := s.casemap(msg.Params[0])
 
-		if s.IsMe(msg.Prefix.Name) {
+		if s.isMe(msg.Prefix.Name) {
 			// TAGMSG from self
 			break
 		}
@@ -768,7 +1904,7 @@ func (s *Session) handleRegistered(msg Message) Event {
 			if t == "active" {
 				s.typings.Active(targetCf, nickCf)
 			} else if t == "paused" {
-				s.typings.Done(targetCf, nickCf)
+				s.typings.Paused(targetCf, nickCf)
 			} else if t == "done" {
 				s.typings.Done(targetCf, nickCf)
 			}
@@ -779,15 +1915,37 @@ func (s *Session) handleRegistered(msg Message) Event {
 
 		if batchStart && msg.Params[1] == "chathistory" {
 			s.chBatches[id] = HistoryEvent{Target: msg.Params[2]}
+		} else if batchStart && msg.Params[1] == "draft/multiline" {
+			s.mlBatches[id] = multilineBatch{
+				Parent: msg.Tags["batch"],
+				Tags:   msg.Tags,
+				Empty:  true,
+			}
+		} else if b, ok := s.mlBatches[id]; ok {
+			delete(s.mlBatches, id)
+			if b.Empty {
+				break
+			}
+			if h, ok := s.chBatches[b.Parent]; ok {
+				s.chBatches[b.Parent] = HistoryEvent{
+					Target:   h.Target,
+					Messages: append(h.Messages, b.Event),
+				}
+				break
+			}
+			targetCf := s.casemap(b.Event.Target)
+			nickCf := s.casemap(b.Event.User)
+			s.typings.Done(targetCf, nickCf)
+			return b.Event
 		} else if b, ok := s.chBatches[id]; ok {
 			delete(s.chBatches, id)
-			delete(s.chReqs, s.Casemap(b.Target))
+			delete(s.chReqs, s.casemap(b.Target))
 			return b
 		}
 	case "NICK":
-		nickCf := s.Casemap(msg.Prefix.Name)
+		nickCf := s.casemap(msg.Prefix.Name)
 		newNick := msg.Params[0]
-		newNickCf := s.Casemap(newNick)
+		newNickCf := s.casemap(newNick)
 
 		if formerUser, ok := s.users[nickCf]; ok {
 			formerUser.Name.Name = newNick
@@ -797,7 +1955,7 @@ func (s *Session) handleRegistered(msg Message) Event {
 			break
 		}
 
-		if s.IsMe(msg.Prefix.Name) {
+		if s.isMe(msg.Prefix.Name) {
 			s.nick = newNick
 			s.nickCf = newNickCf
 			return SelfNickEvent{
@@ -809,10 +1967,64 @@ func (s *Session) handleRegistered(msg Message) Event {
 				FormerNick: msg.Prefix.Name,
 			}
 		}
+	case "MARKREAD":
+		if len(msg.Params) < 2 || !strings.HasPrefix(msg.Params[1], "timestamp=") {
+			// "*" means that no read marker is set.
+			break
+		}
+		t, ok := parseTimestamp(strings.TrimPrefix(msg.Params[1], "timestamp="))
+		if !ok {
+			break
+		}
+		return ReadEvent{
+			Target:    msg.Params[0],
+			Timestamp: t,
+		}
+	case "BOUNCER":
+		if len(msg.Params) < 3 || msg.Params[0] != "NETWORK" {
+			break
+		}
+		ev := BouncerNetworkEvent{ID: msg.Params[1]}
+		if msg.Params[2] == "*" {
+			ev.Deleted = true
+			return ev
+		}
+		attrs := parseTags("@" + msg.Params[2])
+		ev.Name = attrs["name"]
+		ev.State = attrs["state"]
+		ev.Attrs = attrs
+		return ev
+	case "AUTHENTICATE":
+		if s.auth != nil {
+			res, err := s.auth.Respond(msg.Params[0])
+			if err != nil {
+				s.send(NewMessage("AUTHENTICATE", "*"))
+			} else {
+				s.send(NewMessage("AUTHENTICATE", res))
+			}
+		}
+	case rplLoggedin:
+		s.acct = msg.Params[2]
+		s.host = ParsePrefix(msg.Params[1]).Host
+		return LoginEvent{
+			Account: s.acct,
+		}
+	case rplLoggedout:
+		s.acct = ""
+		return LoginEvent{
+			Message: msg.Params[len(msg.Params)-1],
+		}
+	case errNicklocked, errSaslfail, errSasltoolong, errSaslaborted:
+		return LoginEvent{
+			Failed:  true,
+			Message: msg.Params[len(msg.Params)-1],
+		}
+	case rplSaslsuccess, errSaslalready, rplSaslmechs:
+		// The outcome is known from the other replies.
 	case "PING":
-		s.out <- NewMessage("PONG", msg.Params[0])
+		s.send(NewMessage("PONG", msg.Params[0]))
 	case "ERROR":
-		s.Close()
+		s.close()
 	case "FAIL":
 		return ErrorEvent{
 			Severity: SeverityFail,
@@ -843,18 +2055,76 @@ func (s *Session) handleRegistered(msg Message) Event {
 	return nil
 }
 
+// applyUserModes applies a user mode change such as "+o-w" to s.modes.
+func (s *Session) applyUserModes(change string) {
+	add := true
+	for _, m := range change {
+		switch {
+		case m == '+':
+			add = true
+		case m == '-':
+			add = false
+		case add && !strings.ContainsRune(s.modes, m):
+			s.modes += string(m)
+		case !add:
+			s.modes = strings.Replace(s.modes, string(m), "", -1)
+		}
+	}
+}
+
+// isServerPrefix reports whether the message comes from a server rather than
+// from a user.  Nicknames cannot contain dots.
+func isServerPrefix(p *Prefix) bool {
+	return p == nil || p.User == "" && p.Host == "" && strings.Contains(p.Name, ".")
+}
+
+// newServerNoticeEvent parses server notices such as "*** Notice -- Client
+// connecting: ...", where the category is "Client connecting".
+func (s *Session) newServerNoticeEvent(msg Message) ServerNoticeEvent {
+	content := strings.TrimPrefix(msg.Params[1], "*** ")
+	content = strings.TrimPrefix(content, "Notice -- ")
+	ev := ServerNoticeEvent{
+		Content: content,
+		Time:    msg.TimeOrNow(),
+	}
+	if msg.Prefix != nil {
+		ev.Server = msg.Prefix.Name
+	}
+	if i := strings.Index(content, ": "); 0 < i && strings.Count(content[:i], " ") < 3 {
+		ev.Category = content[:i]
+		ev.Content = content[i+2:]
+	}
+	return ev
+}
+
 func (s *Session) newMessageEvent(msg Message) MessageEvent {
-	targetCf := s.Casemap(msg.Params[0])
+	targetCf := s.casemap(msg.Params[0])
+	nickCf := s.casemap(msg.Prefix.Name)
 	ev := MessageEvent{
-		User:    msg.Prefix.Name, // TODO correctly casemap
-		Target:  msg.Params[0],   // TODO correctly casemap
+		User:    msg.Prefix.Name,
+		Prefix:  msg.Prefix.Copy(),
+		Target:  msg.Params[0],
 		Command: msg.Command,
 		Content: msg.Params[1],
 		Time:    msg.TimeOrNow(),
+		MsgID:   msg.Tags["msgid"],
+		Tags:    NewTags(msg.Tags),
+	}
+	if _, ok := msg.Tags["bot"]; ok {
+		ev.Bot = true
+	}
+	if u, ok := s.users[nickCf]; ok {
+		ev.User = u.Name.Name
+		if ev.Bot {
+			u.Bot = true
+		}
+		ev.Bot = u.Bot
 	}
 	if c, ok := s.channels[targetCf]; ok {
 		ev.Target = c.Name
 		ev.TargetIsChannel = true
+	} else if u, ok := s.users[targetCf]; ok {
+		ev.Target = u.Name.Name
 	}
 	return ev
 }
@@ -865,7 +2135,50 @@ func (s *Session) cleanUser(parted *User) {
 			return
 		}
 	}
-	delete(s.users, s.Casemap(parted.Name.Name))
+	delete(s.users, s.casemap(parted.Name.Name))
+}
+
+// setCasemap changes the casemapping of the session, and rebuilds all maps
+// indexed by casemapped names accordingly.
+func (s *Session) setCasemap(casemap func(string) string) {
+	s.casemap = casemap
+	s.nickCf = casemap(s.nick)
+
+	users := make(map[string]*User, len(s.users))
+	for _, u := range s.users {
+		users[casemap(u.Name.Name)] = u
+	}
+	s.users = users
+
+	channels := make(map[string]Channel, len(s.channels))
+	for _, c := range s.channels {
+		channels[casemap(c.Name)] = c
+	}
+	s.channels = channels
+
+	chReqs := make(map[string]string, len(s.chReqs))
+	for _, target := range s.chReqs {
+		chReqs[casemap(target)] = target
+	}
+	s.chReqs = chReqs
+
+	chLists := make(map[listKey]channelList, len(s.chLists))
+	for key, list := range s.chLists {
+		chLists[listKey{casemap(list.Channel), key.mode}] = list
+	}
+	s.chLists = chLists
+
+	pendingChannels := make(map[string]pendingJoin, len(s.pendingChannels))
+	for _, p := range s.pendingChannels {
+		pendingChannels[casemap(p.Channel)] = p
+	}
+	s.pendingChannels = pendingChannels
+
+	typingStamps := make(map[string]typingStamp, len(s.typingStamps))
+	for _, t := range s.typingStamps {
+		typingStamps[casemap(t.Target)] = t
+	}
+	s.typingStamps = typingStamps
 }
 
 func (s *Session) updateFeatures(features []string) {
@@ -903,9 +2216,13 @@ func (s *Session) updateFeatures(features []string) {
 		case "CASEMAPPING":
 			switch value {
 			case "ascii":
-				s.casemap = CasemapASCII
+				s.setCasemap(CasemapASCII)
+			case "rfc1459-strict":
+				s.setCasemap(CasemapRFC1459Strict)
+			case "rfc7613":
+				s.setCasemap(CasemapRFC7613)
 			default:
-				s.casemap = CasemapRFC1459
+				s.setCasemap(CasemapRFC1459)
 			}
 		case "CHANTYPES":
 			s.chantypes = value
@@ -914,6 +2231,26 @@ func (s *Session) updateFeatures(features []string) {
 			if err == nil {
 				s.historyLimit = historyLimit
 			}
+		case "BOT":
+			s.botMode = value
+		case "WHOX":
+			s.whox = true
+		case "CLIENTTAGDENY":
+			s.clientTagDeny = strings.Split(value, ",")
+		case "CHANMODES":
+			s.listModes = strings.SplitN(value, ",", 2)[0]
+		case "TARGMAX":
+			for _, t := range strings.Split(value, ",") {
+				kv := strings.SplitN(t, ":", 2)
+				if len(kv) != 2 {
+					continue
+				}
+				n, _ := strconv.Atoi(kv[1])
+				s.targmax[strings.ToUpper(kv[0])] = n
+			}
+		case "MODES":
+			// No value means that there is no limit.
+			s.maxModes, _ = strconv.Atoi(value)
 		case "LINELEN":
 			linelen, err := strconv.Atoi(value)
 			if err == nil && linelen != 0 {
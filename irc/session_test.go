@@ -0,0 +1,869 @@
+package irc
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestSession returns a registered session with the nickname "me", and the
+// channel of messages it sends.
+func newTestSession(t *testing.T) (*Session, <-chan Message) {
+	out := make(chan Message, 1024)
+	s := NewSession(out, SessionParams{
+		Nickname: "me",
+		Username: "me",
+		RealName: "me",
+	})
+	handleLines(t, s, ":server 001 me :Welcome")
+	for len(out) != 0 {
+		<-out
+	}
+	return s, out
+}
+
+// handleLines feeds the given raw lines to the session, and returns the event
+// of the last one.
+func handleLines(t *testing.T, s *Session, lines ...string) (ev Event) {
+	for _, line := range lines {
+		msg, err := ParseMessage(line)
+		if err != nil {
+			t.Fatalf("%q: %v", line, err)
+		}
+		ev = s.HandleMessage(msg)
+	}
+	return ev
+}
+
+func assertSplitChunks(t *testing.T, s string, chunkLen int, reformat bool, expected []string) {
+	actual := splitChunks(s, chunkLen, reformat)
+	if len(actual) != len(expected) {
+		t.Errorf("%q (len=%d): expected chunks %q, got %q", s, chunkLen, expected, actual)
+		return
+	}
+	for i := range actual {
+		if actual[i] != expected[i] {
+			t.Errorf("%q (len=%d): expected chunks %q, got %q", s, chunkLen, expected, actual)
+			return
+		}
+		if 0 < chunkLen && chunkLen < len(actual[i]) {
+			t.Errorf("%q (len=%d): chunk %q is too long", s, chunkLen, actual[i])
+		}
+	}
+}
+
+func TestSplitChunks(t *testing.T) {
+	assertSplitChunks(t, "hello", 0, true, []string{"hello"})
+	assertSplitChunks(t, "hello", 10, true, []string{"hello"})
+	assertSplitChunks(t, "hello world", 8, true, []string{"hello ", "world"})
+	assertSplitChunks(t, "take care of yourself", 10, true, []string{"take care ", "of ", "yourself"})
+	assertSplitChunks(t, "abcdefghij", 4, true, []string{"abcd", "efgh", "ij"})
+
+	// Words longer than a chunk are cut.
+	assertSplitChunks(t, "a bcdefghij", 8, true, []string{"a ", "bcdefghi", "j"})
+
+	// Grapheme clusters and runes are not split.
+	assertSplitChunks(t, "ééé", 3, true, []string{"é", "é", "é"})
+	assertSplitChunks(t, "éé", 4, true, []string{"é", "é"})
+	assertSplitChunks(t, "é́", 3, true, []string{"é", "́"})
+
+	// Formatting is applied again at the start of each chunk.
+	assertSplitChunks(t, "\x02bold text", 7, true, []string{"\x02bold ", "\x02text"})
+	assertSplitChunks(t, "\x02bold\x02 text", 7, true, []string{"\x02bold\x02 ", "text"})
+	assertSplitChunks(t, "\x0304,12red text", 12, true, []string{"\x0304,12red ", "\x0304,12text"})
+	assertSplitChunks(t, "\x1d\x0304red\x0f text", 10, true, []string{"\x1d\x0304red\x0f ", "text"})
+	assertSplitChunks(t, "\x02bold text", 7, false, []string{"\x02bold ", "text"})
+
+	// The codes put at the start of chunks are not counted again.
+	assertSplitChunks(t, "\x02bold text more words", 7, true, []string{"\x02bold ", "\x02text ", "\x02more ", "\x02words"})
+	assertSplitChunks(t, "\x0304red text more", 10, true, []string{"\x0304red ", "\x0304text ", "\x0304more"})
+
+	// Colors do not run into the digits that follow them.
+	assertSplitChunks(t, "\x034abc 5 ab", 6, true, []string{"\x034abc ", "\x03045 ", "\x0304ab"})
+	assertSplitChunks(t, "\x034,2ab 5 x", 8, true, []string{"\x034,2ab ", "\x0304,025 ", "\x0304,02x"})
+	assertSplitChunks(t, "aaaaa\x0312hello world", 7, true, []string{"aaaaa", "\x0312hell", "\x0312o ", "\x0312worl", "\x0312d"})
+	assertSplitChunks(t, "aaaaa\x04ff0000hello", 9, false, []string{"aaaaa", "\x04ff0000he", "llo"})
+}
+
+func TestKick(t *testing.T) {
+	s, _ := newTestSession(t)
+	handleLines(t, s,
+		":me!me@host JOIN #senpai",
+		":server 353 me = #senpai :me @op alice",
+		":server 366 me #senpai :End of /NAMES list",
+	)
+
+	ev := handleLines(t, s, ":op!op@host KICK #senpai Alice :spam")
+	expected := KickEvent{Kicker: "op", Kicked: "alice", Channel: "#senpai", Reason: "spam"}
+	if ev != expected {
+		t.Errorf("expected %#v, got %#v", expected, ev)
+	}
+	if n := len(s.Names("#senpai")); n != 2 {
+		t.Errorf("expected 2 members left, got %d", n)
+	}
+
+	ev = handleLines(t, s, ":op!op@host KICK #senpai me")
+	expected = KickEvent{Kicker: "op", Kicked: "me", Channel: "#senpai"}
+	if ev != expected {
+		t.Errorf("expected %#v, got %#v", expected, ev)
+	}
+	if names := s.Names("#senpai"); names != nil {
+		t.Errorf("expected the channel to be left, got members %v", names)
+	}
+}
+
+func TestPartQuitReasons(t *testing.T) {
+	s, _ := newTestSession(t)
+	handleLines(t, s,
+		":me!me@host JOIN #senpai",
+		":server 353 me = #senpai :me alice bob",
+		":server 366 me #senpai :End of /NAMES list",
+	)
+
+	ev := handleLines(t, s, ":alice!al@example.org PART #senpai :moving to #other")
+	part, ok := ev.(UserPartEvent)
+	if !ok || part.Reason != "moving to #other" || part.Prefix.Host != "example.org" {
+		t.Errorf("expected a part with a reason and a full prefix, got %#v", ev)
+	}
+
+	ev = handleLines(t, s, ":bob!bob@example.org QUIT :Ping timeout")
+	quit, ok := ev.(UserQuitEvent)
+	if !ok || quit.Reason != "Ping timeout" || quit.Prefix.User != "bob" {
+		t.Errorf("expected a quit with a reason and a full prefix, got %#v", ev)
+	}
+}
+
+func TestChannelList(t *testing.T) {
+	s, _ := newTestSession(t)
+	handleLines(t, s,
+		":me!me@host JOIN #Senpai",
+		":server 353 me = #Senpai :me alice!al@example.org",
+		":server 366 me #Senpai :End of /NAMES list",
+	)
+
+	ev := handleLines(t, s,
+		":server 367 me #senpai *!*@spam.example.org op 1600000000",
+		":server 367 me #senpai troll!*@*",
+		":server 368 me #senpai :End of channel ban list",
+	)
+	l, ok := ev.(ChannelListEvent)
+	if !ok || l.Channel != "#Senpai" || l.Mode != 'b' || len(l.Entries) != 2 {
+		t.Fatalf("expected the ban list of #Senpai, got %#v", ev)
+	}
+	if e := l.Entries[0]; e.Setter != "op" || e.Time.Unix() != 1600000000 {
+		t.Errorf("expected the setter and time of the first entry, got %#v", e)
+	}
+
+	ev = handleLines(t, s, ":server 349 me #senpai :End of channel exception list")
+	if l, ok := ev.(ChannelListEvent); !ok || l.Mode != 'e' || len(l.Entries) != 0 {
+		t.Errorf("expected an empty exception list, got %#v", ev)
+	}
+
+	if mask := s.BanMask("Alice"); mask != "*!*@example.org" {
+		t.Errorf("expected the mask of alice to match her host, got %q", mask)
+	}
+	if mask := s.BanMask("bob"); mask != "bob!*@*" {
+		t.Errorf("expected the mask of an unknown user to match their nick, got %q", mask)
+	}
+}
+
+func TestChangeMemberModes(t *testing.T) {
+	s, out := newTestSession(t)
+	handleLines(t, s, ":server 005 me MODES=2 :are supported by this server")
+	s.ChangeMemberModes("#senpai", '+', 'o', []string{"a", "b", "c"})
+
+	expected := []string{"MODE #senpai +oo a b", "MODE #senpai +o c"}
+	for _, e := range expected {
+		if len(out) == 0 {
+			t.Fatalf("expected %q, got nothing", e)
+		}
+		if msg := <-out; msg.String() != e {
+			t.Errorf("expected %q, got %q", e, msg.String())
+		}
+	}
+	if len(out) != 0 {
+		msg := <-out
+		t.Errorf("expected no more messages, got %q", msg.String())
+	}
+}
+
+func TestJoinMany(t *testing.T) {
+	s, out := newTestSession(t)
+	handleLines(t, s, ":server 005 me TARGMAX=JOIN:2,PRIVMSG: :are supported by this server")
+	s.JoinMany([]string{"#a", "#b", "#c", "#d"}, []string{"", "key", "", ""})
+
+	expected := []string{"JOIN #b,#a key", "JOIN #c,#d"}
+	for _, e := range expected {
+		if len(out) == 0 {
+			t.Fatalf("expected %q, got nothing", e)
+		}
+		if msg := <-out; msg.String() != e {
+			t.Errorf("expected %q, got %q", e, msg.String())
+		}
+	}
+	if len(out) != 0 {
+		msg := <-out
+		t.Errorf("expected no more messages, got %q", msg.String())
+	}
+}
+
+func TestWhox(t *testing.T) {
+	s, out := newTestSession(t)
+	handleLines(t, s,
+		":server 005 me WHOX BOT=B :are supported by this server",
+		":me!me@host JOIN #a",
+		":server 353 me = #a :me alice bob",
+		":server 366 me #a :End of NAMES list",
+		":me!me@host JOIN #b",
+		":server 366 me #b :End of NAMES list",
+	)
+	expectMessage := func(e string) {
+		t.Helper()
+		if len(out) == 0 {
+			t.Fatalf("expected %q, got nothing", e)
+		}
+		if msg := <-out; msg.String() != e {
+			t.Errorf("expected %q, got %q", e, msg.String())
+		}
+		if len(out) != 0 {
+			msg := <-out
+			t.Errorf("expected no more messages, got %q", msg.String())
+		}
+	}
+	expectMessage("WHO #a %tnuhraf,42")
+
+	handleLines(t, s,
+		":server 354 me 42 alice alice.host alice H@ alice :Alice",
+		":server 354 me 42 bob bob.host bob GB 0 :Bob",
+		":server 315 me #a :End of WHO list",
+	)
+	expectMessage("WHO #b %tnuhraf,42")
+
+	alice := s.users["alice"]
+	if alice.Name.Host != "alice.host" || alice.Away || alice.Account != "alice" || alice.RealName != "Alice" {
+		t.Errorf("unexpected user: %+v", *alice)
+	}
+	bob := s.users["bob"]
+	if !bob.Away || !bob.Bot || bob.Account != "" {
+		t.Errorf("unexpected user: %+v", *bob)
+	}
+
+	handleLines(t, s, ":bob!bob@bob.host AWAY")
+	if bob.Away {
+		t.Errorf("expected bob to be back")
+	}
+	handleLines(t, s, ":alice!alice@alice.host AWAY :lunch")
+	if !alice.Away || alice.AwayMsg != "lunch" {
+		t.Errorf("expected alice to be away, got %+v", *alice)
+	}
+
+	// #b never gets a reply; the next channel is not stalled.
+	handleLines(t, s,
+		":me!me@host JOIN #c",
+		":server 366 me #c :End of NAMES list",
+	)
+	if len(out) != 0 {
+		msg := <-out
+		t.Errorf("expected no more messages, got %q", msg.String())
+	}
+	s.whoSent = s.whoSent.Add(-whoTimeout - time.Second)
+	handleLines(t, s,
+		":me!me@host JOIN #d",
+		":server 366 me #d :End of NAMES list",
+	)
+	expectMessage("WHO #c %tnuhraf,42")
+	handleLines(t, s, ":server 403 me #c :No such channel")
+	expectMessage("WHO #d %tnuhraf,42")
+	handleLines(t, s, ":server 263 me WHO :Please wait a while and try again.")
+	if len(s.whoQueue) != 0 {
+		t.Errorf("expected the WHO queue to be empty, got %q", s.whoQueue)
+	}
+}
+
+func TestIdentifyNickServ(t *testing.T) {
+	s, out := newTestSession(t)
+	s.IdentifyNickServ("me", "hunter2")
+	if msg := <-out; msg.String() != "PRIVMSG NickServ :IDENTIFY me hunter2" {
+		t.Errorf("unexpected message %q", msg.String())
+	}
+
+	ev := handleLines(t, s, ":me!me@host PRIVMSG NickServ :IDENTIFY me hunter2")
+	if ev != nil {
+		t.Errorf("expected the echo of the password to be hidden, got %#v", ev)
+	}
+
+	ev = handleLines(t, s, ":me!me@host ACCOUNT me")
+	if ev, ok := ev.(LoginEvent); !ok || ev.Account != "me" {
+		t.Errorf("expected LoginEvent for me, got %#v", ev)
+	}
+	if !s.LoggedIn() {
+		t.Errorf("expected to be logged in")
+	}
+}
+
+func TestServerReplies(t *testing.T) {
+	s, _ := newTestSession(t)
+
+	ev := handleLines(t, s,
+		":server 375 me :- server Message of the day -",
+		":server 372 me :- Welcome",
+		":server 372 me :-",
+		":server 376 me :End of /MOTD command.",
+	)
+	if motd, ok := ev.(MOTDEvent); !ok || motd.Requested || len(motd.Lines) != 2 || motd.Lines[0] != "Welcome" || motd.Lines[1] != "" {
+		t.Errorf("unexpected MOTD event: %#v", ev)
+	}
+	if lines, ok := s.LastMOTD(); !ok || len(lines) != 2 {
+		t.Errorf("expected the MOTD to be kept, got %q", lines)
+	}
+
+	s.MOTD("other.server")
+	handleLines(t, s,
+		":other.server 375 me :- other.server Message of the day -",
+		":other.server 376 me :End of /MOTD command.",
+	)
+	if lines, _ := s.LastMOTD(); len(lines) != 2 {
+		t.Errorf("expected the MOTD of another server not to be kept, got %q", lines)
+	}
+
+	s.MOTD("")
+	ev = handleLines(t, s, ":server 422 me :MOTD File is missing")
+	if motd, ok := ev.(MOTDEvent); !ok || !motd.Requested || motd.Lines != nil {
+		t.Errorf("unexpected MOTD event: %#v", ev)
+	}
+
+	s.Lusers()
+	ev = handleLines(t, s,
+		":server 251 me :There are 3 users and 1 invisible on 1 servers",
+		":server 252 me 2 :IRC Operators online",
+		":server 254 me 5 :channels formed",
+		":server 255 me :I have 4 clients and 0 servers",
+	)
+	expected := LusersEvent{
+		Client:    "There are 3 users and 1 invisible on 1 servers",
+		Operators: 2,
+		Channels:  5,
+		Me:        "I have 4 clients and 0 servers",
+		Requested: true,
+	}
+	if ev != expected {
+		t.Errorf("expected %#v, got %#v", expected, ev)
+	}
+
+	ev = handleLines(t, s,
+		":server 256 me server :Administrative info",
+		":server 257 me :Paris",
+		":server 258 me :",
+		":server 259 me :admin@example.org",
+	)
+	if admin, ok := ev.(AdminEvent); !ok || admin.Server != "server" || len(admin.Location) != 1 || admin.Email != "admin@example.org" {
+		t.Errorf("unexpected ADMIN event: %#v", ev)
+	}
+}
+
+func TestServerNotices(t *testing.T) {
+	s, _ := newTestSession(t)
+
+	ev := handleLines(t, s, ":irc.example.org NOTICE me :*** Notice -- Client connecting: alice (alice@host) [10.0.0.1]")
+	expected := ServerNoticeEvent{
+		Server:   "irc.example.org",
+		Category: "Client connecting",
+		Content:  "alice (alice@host) [10.0.0.1]",
+	}
+	if notice, ok := ev.(ServerNoticeEvent); !ok {
+		t.Errorf("expected ServerNoticeEvent, got %#v", ev)
+	} else if notice.Server != expected.Server || notice.Category != expected.Category || notice.Content != expected.Content {
+		t.Errorf("expected %#v, got %#v", expected, notice)
+	}
+
+	ev = handleLines(t, s, ":alice!alice@host NOTICE me :*** Notice -- hi: there")
+	if _, ok := ev.(MessageEvent); !ok {
+		t.Errorf("expected MessageEvent from a user, got %#v", ev)
+	}
+}
+
+func TestUserModes(t *testing.T) {
+	s, _ := newTestSession(t)
+	handleLines(t, s,
+		":server 221 me +iw",
+		":me MODE me :+o-w",
+	)
+	if modes := s.UserModes(); modes != "io" {
+		t.Errorf("expected modes %q, got %q", "io", modes)
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	out := make(chan Message, 1024)
+	s := NewSession(out, SessionParams{
+		Nickname:     "me",
+		Username:     "me",
+		RealName:     "me",
+		DisabledCaps: []string{"echo-message"},
+	})
+	for len(out) != 0 {
+		<-out
+	}
+	handleLines(t, s, ":server CAP * LS * :echo-message batch")
+	if err := s.RequestCapability("server-time", true); err != nil {
+		t.Fatalf("failed to request server-time: %v", err)
+	}
+	if msg := <-out; msg.String() != "CAP REQ server-time" {
+		t.Errorf("unexpected message %q", msg.String())
+	}
+	ev := handleLines(t, s, ":server CAP * NAK server-time")
+	if ev != (CapabilityEvent{Name: "server-time", Rejected: true}) {
+		t.Errorf("unexpected event before registration %#v", ev)
+	}
+	handleLines(t, s, ":server CAP * LS :unknown=1")
+	if msg := <-out; msg.String() != "CAP REQ batch" {
+		t.Errorf("expected only batch to be requested, got %q", msg.String())
+	}
+	handleLines(t, s,
+		":server CAP * ACK batch",
+		":server 001 me :Welcome",
+	)
+	for len(out) != 0 {
+		<-out
+	}
+
+	caps := s.Capabilities()
+	if len(caps) != 3 || caps[0].Name != "batch" || !caps[0].Enable || caps[2].Name != "unknown" || caps[2].Value != "1" || caps[2].Enable {
+		t.Errorf("unexpected capabilities: %+v", caps)
+	}
+
+	if err := s.RequestCapability("batch", false); err != nil {
+		t.Fatalf("failed to request -batch: %v", err)
+	}
+	if msg := <-out; msg.String() != "CAP REQ -batch" {
+		t.Errorf("unexpected message %q", msg.String())
+	}
+	ev = handleLines(t, s, ":server CAP me ACK -batch")
+	if ev != (CapabilityEvent{Name: "batch"}) {
+		t.Errorf("unexpected event %#v", ev)
+	}
+
+	for _, capability := range []string{"", "unknown"} {
+		if err := s.RequestCapability(capability, true); err == nil {
+			t.Errorf("expected requesting %q to fail", capability)
+		}
+	}
+	if len(out) != 0 {
+		msg := <-out
+		t.Errorf("unexpected message %q", msg.String())
+	}
+}
+
+func TestDisabledSASL(t *testing.T) {
+	out := make(chan Message, 1024)
+	s := NewSession(out, SessionParams{
+		Nickname:     "me",
+		Username:     "me",
+		RealName:     "me",
+		Auth:         &SASLPlain{Username: "me", Password: "secret"},
+		DisabledCaps: []string{"sasl"},
+	})
+	for len(out) != 0 {
+		<-out
+	}
+	handleLines(t, s, ":server CAP * LS :sasl message-tags")
+	var ended bool
+	for len(out) != 0 {
+		msg := <-out
+		if msg.Command == "CAP" && msg.Params[0] == "REQ" && msg.Params[1] == "sasl" {
+			t.Errorf("expected sasl not to be requested")
+		}
+		if msg.Command == "CAP" && msg.Params[0] == "END" {
+			ended = true
+		}
+	}
+	if !ended {
+		t.Errorf("expected registration to end without SASL")
+	}
+}
+
+func TestClientTagDeny(t *testing.T) {
+	s, out := newTestSession(t)
+	handleLines(t, s, ":server CAP me ACK message-tags")
+
+	tests := []struct {
+		deny    string
+		allowed bool
+	}{
+		{"", true},
+		{"typing", false},
+		{"draft/reply,typing", false},
+		{"*", false},
+		{"*,-typing", true},
+		{"*,-draft/reply", false},
+	}
+	for _, test := range tests {
+		handleLines(t, s, ":server 005 me CLIENTTAGDENY="+test.deny+" :are supported by this server")
+		if allowed := s.ClientTagAllowed("+typing"); allowed != test.allowed {
+			t.Errorf("CLIENTTAGDENY=%s: expected +typing allowed to be %v, got %v", test.deny, test.allowed, allowed)
+		}
+	}
+
+	handleLines(t, s, ":server 005 me CLIENTTAGDENY=* :are supported by this server")
+	s.Typing("#senpai")
+	if len(out) != 0 {
+		msg := <-out
+		t.Errorf("expected no typing notification, got %q", msg.String())
+	}
+}
+
+func TestTypingPaused(t *testing.T) {
+	s, out := newTestSession(t)
+	handleLines(t, s, ":server CAP me ACK message-tags")
+
+	s.TypingPause("#senpai")
+	if len(out) != 0 {
+		msg := <-out
+		t.Errorf("expected no paused notification before typing, got %q", msg.String())
+	}
+	s.Typing("#senpai")
+	s.TypingPause("#senpai")
+	s.Typing("#senpai")
+	for _, e := range []string{"active", "paused", "active"} {
+		if len(out) == 0 {
+			t.Fatalf("expected +typing=%s, got nothing", e)
+		}
+		if msg := <-out; msg.Command != "TAGMSG" || msg.Tags["+typing"] != e {
+			t.Errorf("expected +typing=%s, got %q", e, msg.String())
+		}
+	}
+
+	handleLines(t, s, "@+typing=paused :alice!alice@host TAGMSG #senpai")
+	if ts := s.Typings("#senpai"); len(ts) != 0 {
+		t.Errorf("expected nobody to be typing, got %q", ts)
+	}
+	if ts := s.PausedTypings("#senpai"); len(ts) != 1 || ts[0] != "alice" {
+		t.Errorf("expected alice to have paused, got %q", ts)
+	}
+	handleLines(t, s, "@+typing=active :alice!alice@host TAGMSG #senpai")
+	if ts := s.Typings("#senpai"); len(ts) != 1 || ts[0] != "alice" {
+		t.Errorf("expected alice to be typing, got %q", ts)
+	}
+}
+
+func TestChangeMemberModesLineLen(t *testing.T) {
+	s, out := newTestSession(t)
+	handleLines(t, s, ":server 005 me MODES :are supported by this server")
+	var nicks []string
+	for i := 0; i < 100; i++ {
+		nicks = append(nicks, fmt.Sprintf("nickname%02d", i))
+	}
+	s.ChangeMemberModes("#senpai", '+', 'o', nicks)
+
+	var sent []string
+	for len(out) != 0 {
+		msg := <-out
+		if 512 < len(":me!me@255.255.255.255 "+msg.String()+"\r\n") {
+			t.Errorf("line too long: %q", msg.String())
+		}
+		sent = append(sent, msg.Params[2:]...)
+	}
+	if len(sent) != len(nicks) {
+		t.Errorf("expected %d nicks to be opped, got %d", len(nicks), len(sent))
+	}
+}
+
+// newMultilineSession returns a test session that supports draft/multiline
+// with the given value.
+func newMultilineSession(t *testing.T, value string) (*Session, <-chan Message) {
+	s, out := newTestSession(t)
+	handleLines(t, s,
+		":server CAP me NEW :batch draft/multiline="+value,
+		":server CAP me ACK :batch draft/multiline",
+	)
+	for len(out) != 0 {
+		<-out
+	}
+	return s, out
+}
+
+// readBatches returns the contents of the PRIVMSGs sent in each
+// draft/multiline batch.
+func readBatches(t *testing.T, out <-chan Message) (batches [][]Message) {
+	var id string
+	for len(out) != 0 {
+		msg := <-out
+		switch {
+		case msg.Command == "BATCH" && msg.Params[0][0] == '+':
+			id = msg.Params[0][1:]
+			batches = append(batches, nil)
+		case msg.Command == "BATCH":
+			id = ""
+		case msg.Command == "PRIVMSG" && id != "" && msg.Tags["batch"] == id:
+			batches[len(batches)-1] = append(batches[len(batches)-1], msg)
+		default:
+			t.Errorf("unexpected message outside of a batch: %q", msg.String())
+		}
+	}
+	return batches
+}
+
+func TestPrivMsgMultiline(t *testing.T) {
+	s, out := newMultilineSession(t, "max-bytes=40,max-lines=3")
+	s.PrivMsg("#senpai", "a\nb\nc\nd")
+	batches := readBatches(t, out)
+	if len(batches) != 2 || len(batches[0]) != 3 || len(batches[1]) != 1 || batches[1][0].Params[1] != "d" {
+		t.Errorf("expected max-lines to split the batches, got %v", batches)
+	}
+
+	lines := []string{
+		strings.Repeat("a", 15),
+		strings.Repeat("b", 15),
+		strings.Repeat("c", 15),
+	}
+	s.PrivMsg("#senpai", strings.Join(lines, "\n"))
+	batches = readBatches(t, out)
+	if len(batches) != 2 || len(batches[0]) != 2 || len(batches[1]) != 1 || batches[1][0].Params[1] != lines[2] {
+		t.Errorf("expected max-bytes to split the batches, got %v", batches)
+	}
+
+	s, out = newMultilineSession(t, "max-bytes=4096")
+	long := strings.Repeat("x", 600)
+	s.PrivMsg("#senpai", "first\n"+long)
+	batches = readBatches(t, out)
+	if len(batches) != 1 || len(batches[0]) != 3 {
+		t.Fatalf("expected one batch of 3 messages, got %v", batches)
+	}
+	if _, ok := batches[0][1].Tags["draft/multiline-concat"]; ok {
+		t.Errorf("expected the first chunk of a line not to be concatenated")
+	}
+	if _, ok := batches[0][2].Tags["draft/multiline-concat"]; !ok {
+		t.Errorf("expected the rest of a long line to be concatenated")
+	}
+	if batches[0][1].Params[1]+batches[0][2].Params[1] != long {
+		t.Errorf("expected the chunks to make up the long line")
+	}
+}
+
+func TestMultilineBatch(t *testing.T) {
+	s, _ := newTestSession(t)
+	handleLines(t, s, ":me!me@host JOIN #senpai")
+	ev := handleLines(t, s,
+		"@msgid=abc;time=2021-01-01T00:00:00.000Z :alice!alice@host BATCH +ml draft/multiline #senpai",
+		"@batch=ml :alice!alice@host PRIVMSG #senpai :hello",
+		"@batch=ml;draft/multiline-concat :alice!alice@host PRIVMSG #senpai : world",
+		"@batch=ml :alice!alice@host PRIVMSG #senpai :second line",
+	)
+	if ev != nil {
+		t.Errorf("expected no event before the end of the batch, got %#v", ev)
+	}
+	ev = handleLines(t, s, ":server BATCH -ml")
+	msg, ok := ev.(MessageEvent)
+	if !ok {
+		t.Fatalf("expected a MessageEvent, got %#v", ev)
+	}
+	if msg.User != "alice" || !msg.TargetIsChannel || msg.Content != "hello world\nsecond line" || msg.MsgID != "abc" || msg.Time.Year() != 2021 {
+		t.Errorf("unexpected message %#v", msg)
+	}
+
+	ev = handleLines(t, s,
+		":alice!alice@host BATCH +empty draft/multiline #senpai",
+		":server BATCH -empty",
+	)
+	if ev != nil {
+		t.Errorf("expected no event for an empty batch, got %#v", ev)
+	}
+}
+
+func TestCasemapChange(t *testing.T) {
+	s, _ := newTestSession(t)
+	handleLines(t, s,
+		":server CAP me ACK :message-tags draft/chathistory",
+		":me!me@host JOIN #a[b]",
+		":server 353 me = #a[b] :me Nick[1]",
+		":server 366 me #a[b] :End of NAMES list",
+		":server 367 me #a[b] *!*@spam",
+	)
+	s.Join("#c[d]", "")
+	s.Typing("#a[b]")
+	s.NewHistoryRequest("#a[b]").WithLimit(10).Before(time.Now())
+
+	handleLines(t, s, ":server 005 me CASEMAPPING=ascii :are supported by this server")
+
+	if _, ok := s.channels["#a[b]"]; !ok {
+		t.Errorf("expected #a[b] to be tracked, got %v", s.channels)
+	}
+	if _, ok := s.users["nick[1]"]; !ok {
+		t.Errorf("expected Nick[1] to be tracked, got %v", s.users)
+	}
+	if _, ok := s.chReqs["#a[b]"]; !ok {
+		t.Errorf("expected the history request of #a[b] to be tracked, got %v", s.chReqs)
+	}
+	if _, ok := s.pendingChannels["#c[d]"]; !ok {
+		t.Errorf("expected the join of #c[d] to be tracked, got %v", s.pendingChannels)
+	}
+	if _, ok := s.typingStamps["#a[b]"]; !ok {
+		t.Errorf("expected the typing stamp of #a[b] to be tracked, got %v", s.typingStamps)
+	}
+	ev := handleLines(t, s, ":server 368 me #a[b] :End of channel ban list")
+	if ev, ok := ev.(ChannelListEvent); !ok || ev.Channel != "#a[b]" || len(ev.Entries) != 1 {
+		t.Errorf("expected the ban list of #a[b], got %#v", ev)
+	}
+}
+
+// TestConcurrentAccess is meant to be run with -race.
+func TestConcurrentAccess(t *testing.T) {
+	s, out := newTestSession(t)
+	handleLines(t, s,
+		"CAP * ACK :message-tags",
+		":me!me@host JOIN #senpai",
+		":server 353 me = #senpai :me",
+		":server 366 me #senpai :End of /NAMES list",
+	)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-out:
+			case <-done:
+				return
+			}
+		}
+	}()
+	defer close(done)
+
+	var wg sync.WaitGroup
+	wg.Add(5)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			nick := fmt.Sprintf("user%d", i)
+			handleLines(t, s,
+				fmt.Sprintf(":%s!u@h JOIN #senpai", nick),
+				fmt.Sprintf("@+typing=active :%s!u@h TAGMSG #senpai", nick),
+				fmt.Sprintf(":%s!u@h PRIVMSG #senpai :hello", nick),
+				fmt.Sprintf(":%s!u@h PART #senpai", nick),
+			)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			s.Names("#senpai")
+			s.Users()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			s.Typings("#senpai")
+			s.Typing("#senpai")
+		}
+	}()
+	started := make(chan struct{})
+	closed := make(chan struct{})
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			s.PrivMsg("#senpai", "hello")
+			s.Join("#other", "")
+			if i == 0 {
+				close(started)
+			} else if i == 50 {
+				// Sends must be dropped once the session is
+				// closed.
+				<-closed
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		<-started
+		s.Close()
+		close(closed)
+	}()
+	wg.Wait()
+
+	if n := len(s.Names("#senpai")); n != 1 {
+		t.Errorf("expected 1 member left, got %d", n)
+	}
+}
+
+func TestMessageEventMetadata(t *testing.T) {
+	s, _ := newTestSession(t)
+	ev := handleLines(t, s, "@msgid=abc;+draft/reply=xyz :alice!al@example.org PRIVMSG me :hello")
+	m, ok := ev.(MessageEvent)
+	if !ok {
+		t.Fatalf("expected a MessageEvent, got %#v", ev)
+	}
+	if m.MsgID != "abc" || m.Prefix.User != "al" || m.Prefix.Host != "example.org" {
+		t.Errorf("expected the msgid and full prefix, got %#v", m)
+	}
+	if v, ok := m.Tags.Get("+draft/reply"); !ok || v != "xyz" {
+		t.Errorf("expected the client tag, got %q", v)
+	}
+	tags := m.Tags.Map()
+	tags["msgid"] = "changed"
+	if v, _ := m.Tags.Get("msgid"); v != "abc" {
+		t.Errorf("expected the tags to be read-only, got msgid %q", v)
+	}
+}
+
+func TestBots(t *testing.T) {
+	s, _ := newTestSession(t)
+	handleLines(t, s,
+		":server 005 me BOT=B :are supported by this server",
+		":me!me@host JOIN #senpai",
+		":server 353 me = #senpai :me ci alice",
+		":server 366 me #senpai :End of /NAMES list",
+		":server 352 me #senpai ci ci.example.org server ci HB :0 CI bot",
+		":server 352 me #senpai alice example.org server alice H :0 Alice",
+	)
+	if !s.IsBot("ci") || s.IsBot("alice") {
+		t.Errorf("expected ci to be a bot from WHO replies")
+	}
+
+	ev := handleLines(t, s, ":ci!ci@ci.example.org PRIVMSG #senpai :build passed")
+	if m, ok := ev.(MessageEvent); !ok || !m.Bot {
+		t.Errorf("expected a message from a bot, got %#v", ev)
+	}
+	ev = handleLines(t, s, "@bot :alice!alice@example.org PRIVMSG #senpai :beep")
+	if m, ok := ev.(MessageEvent); !ok || !m.Bot || !s.IsBot("alice") {
+		t.Errorf("expected the bot tag to mark alice as a bot, got %#v", ev)
+	}
+}
+
+func TestSASLAfterCapNew(t *testing.T) {
+	out := make(chan Message, 1024)
+	s := NewSession(out, SessionParams{
+		Nickname: "me",
+		Username: "me",
+		RealName: "me",
+		Auth:     &SASLPlain{Username: "me", Password: "secret"},
+	})
+	handleLines(t, s,
+		"CAP * LS :cap-notify",
+		"CAP * ACK :cap-notify",
+		":server 001 me :Welcome",
+	)
+	for len(out) != 0 {
+		<-out
+	}
+
+	handleLines(t, s, "CAP * NEW :sasl", "CAP * ACK :sasl")
+	var sent []string
+	for len(out) != 0 {
+		msg := <-out
+		sent = append(sent, msg.String())
+	}
+	expected := []string{"CAP REQ sasl", "AUTHENTICATE PLAIN"}
+	if len(sent) != len(expected) || sent[0] != expected[0] || sent[1] != expected[1] {
+		t.Fatalf("expected %q, got %q", expected, sent)
+	}
+
+	handleLines(t, s, "AUTHENTICATE +")
+	if msg := <-out; msg.Command != "AUTHENTICATE" || msg.Params[0] == "*" {
+		t.Errorf("expected the SASL response, got %q", msg.String())
+	}
+	ev := handleLines(t, s, ":server 900 me me!me@host me :You are now logged in as me")
+	if ev != (LoginEvent{Account: "me"}) || !s.LoggedIn() {
+		t.Errorf("expected to be logged in, got %#v", ev)
+	}
+}
@@ -6,6 +6,10 @@ import (
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+	"golang.org/x/text/width"
 )
 
 // CasemapASCII of name is the canonical representation of name according to the
@@ -44,6 +48,47 @@ func CasemapRFC1459(name string) string {
 	return sb.String()
 }
 
+// CasemapRFC1459Strict of name is the canonical representation of name
+// according to the rfc1459-strict casemapping, which is the same as rfc1459's
+// except that '~' and '^' are different characters.
+func CasemapRFC1459Strict(name string) string {
+	var sb strings.Builder
+	sb.Grow(len(name))
+	for _, r := range name {
+		if 'A' <= r && r <= 'Z' {
+			r += 'a' - 'A'
+		} else if r == '[' {
+			r = '{'
+		} else if r == ']' {
+			r = '}'
+		} else if r == '\\' {
+			r = '|'
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// CasemapRFC7613 of name is the canonical representation of name according to
+// the rfc7613 casemapping, which follows the mapping rules of the PRECIS
+// UsernameCaseMapped profile: fullwidth and halfwidth characters are mapped to
+// their decomposition, then the name is lowercased and normalized to NFC.
+func CasemapRFC7613(name string) string {
+	ascii := true
+	for i := 0; i < len(name); i++ {
+		if utf8.RuneSelf <= name[i] {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		return CasemapASCII(name)
+	}
+	name = width.Fold.String(name)
+	name = strings.ToLower(name)
+	return norm.NFC.String(name)
+}
+
 // word returns the first word of s and the rest of s.
 func word(s string) (word, rest string) {
 	split := strings.SplitN(s, " ", 2)
@@ -132,6 +177,36 @@ func escapeTagValue(unescaped string) string {
 	return sb.String()
 }
 
+// Tags is a read-only set of message tags.  Its zero value is an empty set.
+type Tags struct {
+	tags map[string]string
+}
+
+// NewTags returns a set holding a copy of the given tags.
+func NewTags(tags map[string]string) Tags {
+	copied := make(map[string]string, len(tags))
+	for k, v := range tags {
+		copied[k] = v
+	}
+	return Tags{tags: copied}
+}
+
+// Get returns the value of the given tag, and whether it is present.
+func (t Tags) Get(key string) (value string, ok bool) {
+	value, ok = t.tags[key]
+	return
+}
+
+// Len returns the number of tags.
+func (t Tags) Len() int {
+	return len(t.tags)
+}
+
+// Map returns a copy of the tags as a map.
+func (t Tags) Map() map[string]string {
+	return NewTags(t.tags).tags
+}
+
 func parseTags(s string) (tags map[string]string) {
 	s = s[1:]
 	tags = map[string]string{}
@@ -332,7 +407,7 @@ func (msg *Message) String() string {
 			sb.WriteString(p)
 		}
 		lastParam := msg.Params[len(msg.Params)-1]
-		if !strings.ContainsRune(lastParam, ' ') && !strings.HasPrefix(lastParam, ":") {
+		if lastParam != "" && !strings.ContainsRune(lastParam, ' ') && !strings.HasPrefix(lastParam, ":") {
 			sb.WriteRune(' ')
 			sb.WriteString(lastParam)
 		} else {
@@ -350,19 +425,23 @@ func (msg *Message) IsValid() bool {
 	switch msg.Command {
 	case "AUTHENTICATE", "PING", "PONG":
 		return 1 <= len(msg.Params)
-	case rplEndofnames, rplLoggedout, rplMotd, errNicknameinuse, rplNotopic, rplWelcome, rplYourhost:
+	case rplEndofnames, rplEndofwho, rplLoggedout, rplMotd, errNicknameinuse, rplNotopic, rplWelcome, rplYourhost:
+		return 2 <= len(msg.Params)
+	case rplLuserclient, rplLuserop, rplLuserunknown, rplLuserchannels, rplLuserme:
+		return 2 <= len(msg.Params)
+	case rplAdminme, rplAdminloc1, rplAdminloc2, rplAdminmail, rplInfo:
 		return 2 <= len(msg.Params)
-	case rplIsupport, rplLoggedin, rplTopic, "FAIL", "WARN", "NOTE":
+	case rplIsupport, rplLoggedin, rplTopic, rplVersion, "FAIL", "WARN", "NOTE":
 		return 3 <= len(msg.Params)
 	case rplNamreply:
 		return 4 <= len(msg.Params)
-	case rplWhoreply:
+	case rplWhoreply, rplWhospcrpl:
 		return 8 <= len(msg.Params)
-	case "JOIN", "NICK", "PART", "TAGMSG":
+	case "ACCOUNT", "JOIN", "NICK", "PART", "TAGMSG":
 		return 1 <= len(msg.Params) && msg.Prefix != nil
 	case "KICK", "PRIVMSG", "NOTICE", "TOPIC":
 		return 2 <= len(msg.Params) && msg.Prefix != nil
-	case "QUIT":
+	case "AWAY", "QUIT":
 		return msg.Prefix != nil
 	case "CAP":
 		return 3 <= len(msg.Params) &&
@@ -390,7 +469,7 @@ func (msg *Message) IsValid() bool {
 				return false
 			}
 			switch msg.Params[1] {
-			case "chathistory":
+			case "chathistory", "draft/multiline":
 				return 3 <= len(msg.Params)
 			default:
 				return false
@@ -408,23 +487,27 @@ func (msg *Message) IsValid() bool {
 
 // Time returns the time when the message has been sent, if present.
 func (msg *Message) Time() (t time.Time, ok bool) {
-	var tag string
-	var year, month, day, hour, minute, second, millis int
-
-	tag, ok = msg.Tags["time"]
+	tag, ok := msg.Tags["time"]
 	if !ok {
 		return
 	}
+	return parseTimestamp(tag)
+}
+
+// parseTimestamp parses timestamps in the format of the "time" tag
+// (YYYY-MM-DDThh:mm:ss.sssZ).
+func parseTimestamp(tag string) (t time.Time, ok bool) {
+	var year, month, day, hour, minute, second, millis int
 
 	tag = strings.TrimSuffix(tag, "Z")
 
 	_, err := fmt.Sscanf(tag, "%4d-%2d-%2dT%2d:%2d:%2d.%3d", &year, &month, &day, &hour, &minute, &second, &millis)
 	if err != nil || month < 1 || 12 < month {
-		ok = false
 		return
 	}
 
 	t = time.Date(year, time.Month(month), day, hour, minute, second, millis*1e6, time.UTC)
+	ok = true
 	return
 }
 
@@ -508,6 +591,7 @@ func ParseCaps(caps string) (diff []Cap) {
 type Member struct {
 	PowerLevel string
 	Name       *Prefix
+	Away       bool
 }
 
 type members []Member
@@ -0,0 +1,27 @@
+package irc
+
+import "testing"
+
+func TestCasemap(t *testing.T) {
+	tests := []struct {
+		casemap  func(string) string
+		name     string
+		input    string
+		expected string
+	}{
+		{CasemapASCII, "ascii", "Foo[Bar]~", "foo[bar]~"},
+		{CasemapASCII, "ascii", "ÉCOLE", "École"},
+		{CasemapRFC1459, "rfc1459", "Foo[Bar]\\~", "foo{bar}|^"},
+		{CasemapRFC1459Strict, "rfc1459-strict", "Foo[Bar]\\~", "foo{bar}|~"},
+		{CasemapRFC7613, "rfc7613", "Foo[Bar]~", "foo[bar]~"},
+		{CasemapRFC7613, "rfc7613", "ÉCOLE", "école"},
+		{CasemapRFC7613, "rfc7613", "Ｆｏｏ", "foo"},
+		{CasemapRFC7613, "rfc7613", "E\u0301cole", "école"},
+	}
+	for _, test := range tests {
+		actual := test.casemap(test.input)
+		if actual != test.expected {
+			t.Errorf("%s(%q): expected %q, got %q", test.name, test.input, test.expected, actual)
+		}
+	}
+}
@@ -13,18 +13,38 @@ type Typing struct {
 	Name   string
 }
 
+// Typing notifications expire after these durations without update.
+const (
+	typingActiveTimeout = 6 * time.Second
+	typingPausedTimeout = 30 * time.Second
+)
+
+// typingState is the last typing notification of a user.
+type typingState struct {
+	At     time.Time
+	Paused bool
+}
+
+// timeout returns how long the notification lasts.
+func (st typingState) timeout() time.Duration {
+	if st.Paused {
+		return typingPausedTimeout
+	}
+	return typingActiveTimeout
+}
+
 // Typings keeps track of typing notification timeouts.
 type Typings struct {
 	l        sync.Mutex
-	targets  map[Typing]time.Time // @+typing TAGMSG timestamps.
-	timeouts chan Typing          // transmits unfiltered timeout notifications.
-	stops    chan Typing          // transmits filtered timeout notifications.
+	targets  map[Typing]typingState // @+typing TAGMSG timestamps.
+	timeouts chan Typing            // transmits unfiltered timeout notifications.
+	stops    chan Typing            // transmits filtered timeout notifications.
 }
 
 // NewTypings initializes the Typings structures and filtering coroutine.
 func NewTypings() *Typings {
 	ts := &Typings{
-		targets:  map[Typing]time.Time{},
+		targets:  map[Typing]typingState{},
 		timeouts: make(chan Typing, 16),
 		stops:    make(chan Typing, 16),
 	}
@@ -33,7 +53,7 @@ func NewTypings() *Typings {
 			now := time.Now()
 			ts.l.Lock()
 			oldT, ok := ts.targets[t]
-			if ok && 6.0 < now.Sub(oldT).Seconds() {
+			if ok && oldT.timeout() < now.Sub(oldT.At) {
 				delete(ts.targets, t)
 				ts.l.Unlock()
 				ts.stops <- t
@@ -58,13 +78,22 @@ func (ts *Typings) Stops() <-chan Typing {
 
 // Active should be called when a user is typing to some target.
 func (ts *Typings) Active(target, name string) {
+	ts.set(Typing{target, name}, typingState{At: time.Now()})
+}
+
+// Paused should be called when a user has stopped typing to some target, but
+// has not cleared their message.
+func (ts *Typings) Paused(target, name string) {
+	ts.set(Typing{target, name}, typingState{At: time.Now(), Paused: true})
+}
+
+func (ts *Typings) set(t Typing, st typingState) {
 	ts.l.Lock()
-	t := Typing{target, name}
-	ts.targets[t] = time.Now()
+	ts.targets[t] = st
 	ts.l.Unlock()
 
 	go func() {
-		time.Sleep(6 * time.Second)
+		time.Sleep(st.timeout())
 		ts.timeouts <- t
 	}()
 }
@@ -76,13 +105,15 @@ func (ts *Typings) Done(target, name string) {
 	ts.l.Unlock()
 }
 
-func (ts *Typings) List(target string) []string {
+// List returns the users who are typing to target, or who have paused if
+// paused is true.
+func (ts *Typings) List(target string, paused bool) []string {
 	ts.l.Lock()
 	defer ts.l.Unlock()
 
 	var res []string
-	for t := range ts.targets {
-		if target == t.Target {
+	for t, st := range ts.targets {
+		if target == t.Target && st.Paused == paused {
 			res = append(res, t.Name)
 		}
 	}
@@ -90,7 +121,8 @@ func (ts *Typings) List(target string) []string {
 }
 
 type typingStamp struct {
-	Last  time.Time
-	Type  int
-	Limit *rate.Limiter
+	Target string // the original name of the target, before casemapping.
+	Last   time.Time
+	Type   int
+	Limit  *rate.Limiter
 }
@@ -0,0 +1,317 @@
+package senpai
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"git.sr.ht/~taiite/senpai/ui"
+	"github.com/gdamore/tcell/v2"
+)
+
+// proxyURL returns the proxy senpai must connect to addr through, or nil if the
+// connection must be direct.  The "proxy" setting takes precedence over the
+// ALL_PROXY environment variable, which is ignored for the hosts listed in
+// NO_PROXY, or with a warning if it is not supported.
+func (app *App) proxyURL(netID, addr string) (*url.URL, error) {
+	if app.cfg.Proxy != "" {
+		return parseProxy(app.cfg.Proxy)
+	}
+	raw := getenv("ALL_PROXY")
+	if raw == "" || isNoProxy(getenv("NO_PROXY"), addr) {
+		return nil, nil
+	}
+	u, err := parseProxy(raw)
+	if err != nil {
+		app.queueStatusLine(netID, ui.Line{
+			Head:      "!!",
+			HeadColor: tcell.ColorRed,
+			Body:      ui.PlainSprintf("Ignoring ALL_PROXY: %v", err),
+		})
+		return nil, nil
+	}
+	return u, nil
+}
+
+// getenv returns the value of the given environment variable, or of its
+// lowercase version if it is not set.
+func getenv(key string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return os.Getenv(strings.ToLower(key))
+}
+
+// isNoProxy reports whether addr matches the given NO_PROXY value: a comma
+// separated list of host names, domains (which match their subdomains), IP
+// addresses and CIDR ranges, optionally with a port, or "*".
+func isNoProxy(noProxy, addr string) bool {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	host = strings.ToLower(host)
+	ip := net.ParseIP(host)
+	for _, entry := range strings.Split(noProxy, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			return true
+		}
+		if _, ipNet, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && ipNet.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if h, p, err := net.SplitHostPort(entry); err == nil {
+			if p != port {
+				continue
+			}
+			entry = h
+		}
+		if entryIP := net.ParseIP(entry); entryIP != nil {
+			if ip != nil && entryIP.Equal(ip) {
+				return true
+			}
+			continue
+		}
+		entry = strings.TrimPrefix(entry, ".")
+		if host == entry || strings.HasSuffix(host, "."+entry) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseProxy parses the URL of a proxy, and adds the default port of its scheme
+// if it has none.
+func parseProxy(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy %q: %v", raw, err)
+	}
+	switch u.Scheme {
+	case "socks5", "socks5h", "http":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+	}
+	if u.Port() == "" {
+		if u.Scheme == "http" {
+			u.Host = net.JoinHostPort(u.Hostname(), "8080")
+		} else {
+			u.Host = net.JoinHostPort(u.Hostname(), "1080")
+		}
+	}
+	return u, nil
+}
+
+// dialProxy opens a TCP connection to addr through the given proxy.
+func dialProxy(proxy *url.URL, addr string) (conn net.Conn, err error) {
+	conn, err = net.Dial("tcp", proxy.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	switch proxy.Scheme {
+	case "socks5", "socks5h":
+		err = socks5Connect(conn, proxy.User, addr)
+	case "http":
+		err = httpConnect(conn, proxy.User, addr)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s: %v", proxy.Host, err)
+	}
+
+	return conn, nil
+}
+
+// httpConnect asks an HTTP proxy to open a tunnel to addr, as described in
+// RFC 7231 section 4.3.6.
+func httpConnect(conn net.Conn, user *url.Userinfo, addr string) error {
+	req := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if user != nil {
+		password, _ := user.Password()
+		creds := user.Username() + ":" + password
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(creds)))
+	}
+	err := req.Write(conn)
+	if err != nil {
+		return err
+	}
+
+	// Read the response header byte by byte, so that nothing past it (the
+	// beginning of the IRC stream) is consumed.
+	var head []byte
+	var b [1]byte
+	for !bytes.HasSuffix(head, []byte("\r\n\r\n")) {
+		if 4096 < len(head) {
+			return errors.New("response header too long")
+		}
+		if _, err = io.ReadFull(conn, b[:]); err != nil {
+			return err
+		}
+		head = append(head, b[0])
+	}
+	res, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(head)), req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("CONNECT failed: %s", res.Status)
+	}
+	return nil
+}
+
+const (
+	socks5Version = 0x05
+
+	socks5AuthNone     = 0x00
+	socks5AuthPassword = 0x02
+	socks5AuthNoAccept = 0xFF
+
+	socks5CmdConnect = 0x01
+
+	socks5AddrIPv4   = 0x01
+	socks5AddrDomain = 0x03
+	socks5AddrIPv6   = 0x04
+)
+
+var socks5Errors = []string{
+	"",
+	"general SOCKS server failure",
+	"connection not allowed by ruleset",
+	"network unreachable",
+	"host unreachable",
+	"connection refused",
+	"TTL expired",
+	"command not supported",
+	"address type not supported",
+}
+
+// socks5Connect asks a SOCKS5 proxy to open a tunnel to addr, as described in
+// RFC 1928 and RFC 1929.  The host name is always resolved by the proxy, so
+// that .onion addresses work.
+func socks5Connect(conn net.Conn, user *url.Userinfo, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid port %q", portStr)
+	}
+
+	method := byte(socks5AuthNone)
+	if user != nil {
+		method = socks5AuthPassword
+	}
+	_, err = conn.Write([]byte{socks5Version, 1, method})
+	if err != nil {
+		return err
+	}
+
+	var buf [4]byte
+	if _, err = io.ReadFull(conn, buf[:2]); err != nil {
+		return err
+	}
+	if buf[0] != socks5Version {
+		return fmt.Errorf("unexpected SOCKS version %d", buf[0])
+	}
+	switch buf[1] {
+	case socks5AuthNone:
+	case socks5AuthPassword:
+		if user == nil {
+			return errors.New("SOCKS server requires authentication")
+		}
+		password, _ := user.Password()
+		if 255 < len(user.Username()) || 255 < len(password) {
+			return errors.New("SOCKS username or password too long")
+		}
+		req := []byte{0x01, byte(len(user.Username()))}
+		req = append(req, user.Username()...)
+		req = append(req, byte(len(password)))
+		req = append(req, password...)
+		if _, err = conn.Write(req); err != nil {
+			return err
+		}
+		if _, err = io.ReadFull(conn, buf[:2]); err != nil {
+			return err
+		}
+		if buf[1] != 0x00 {
+			return errors.New("SOCKS authentication failed")
+		}
+	case socks5AuthNoAccept:
+		return errors.New("no acceptable SOCKS authentication method")
+	default:
+		return fmt.Errorf("unexpected SOCKS authentication method %d", buf[1])
+	}
+
+	req := []byte{socks5Version, socks5CmdConnect, 0x00}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			req = append(req, socks5AddrIPv4)
+			req = append(req, ip4...)
+		} else {
+			req = append(req, socks5AddrIPv6)
+			req = append(req, ip...)
+		}
+	} else {
+		if 255 < len(host) {
+			return errors.New("host name too long")
+		}
+		req = append(req, socks5AddrDomain, byte(len(host)))
+		req = append(req, host...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+	if _, err = conn.Write(req); err != nil {
+		return err
+	}
+
+	if _, err = io.ReadFull(conn, buf[:4]); err != nil {
+		return err
+	}
+	if buf[1] != 0x00 {
+		if int(buf[1]) < len(socks5Errors) {
+			return errors.New(socks5Errors[buf[1]])
+		}
+		return fmt.Errorf("unknown SOCKS error %d", buf[1])
+	}
+
+	// Discard the bound address.
+	var boundLen int
+	switch buf[3] {
+	case socks5AddrIPv4:
+		boundLen = net.IPv4len
+	case socks5AddrIPv6:
+		boundLen = net.IPv6len
+	case socks5AddrDomain:
+		if _, err = io.ReadFull(conn, buf[:1]); err != nil {
+			return err
+		}
+		boundLen = int(buf[0])
+	default:
+		return fmt.Errorf("unexpected SOCKS address type %d", buf[3])
+	}
+	_, err = io.CopyN(ioutil.Discard, conn, int64(boundLen)+2)
+	return err
+}
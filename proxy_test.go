@@ -0,0 +1,170 @@
+package senpai
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestIsNoProxy(t *testing.T) {
+	tests := []struct {
+		noProxy  string
+		addr     string
+		expected bool
+	}{
+		{"", "irc.example.org:6697", false},
+		{"*", "irc.example.org:6697", true},
+		{"example.org", "irc.example.org:6697", true},
+		{".example.org", "irc.example.org:6697", true},
+		{"example.org", "example.org:6697", true},
+		{"example.org", "notexample.org:6697", false},
+		{"localhost, 127.0.0.1", "127.0.0.1:6667", true},
+		{"10.0.0.0/8", "10.1.2.3:6697", true},
+		{"10.0.0.0/8", "192.168.1.1:6697", false},
+		{"example.org:6667", "irc.example.org:6697", false},
+		{"example.org:6697", "irc.example.org:6697", true},
+		{"[::1]:6697", "[::1]:6697", true},
+	}
+	for _, test := range tests {
+		if actual := isNoProxy(test.noProxy, test.addr); actual != test.expected {
+			t.Errorf("NO_PROXY=%q, %q: expected %v, got %v", test.noProxy, test.addr, test.expected, actual)
+		}
+	}
+}
+
+func TestParseProxy(t *testing.T) {
+	u, err := parseProxy("socks5://proxy.example.org")
+	if err != nil || u.Host != "proxy.example.org:1080" {
+		t.Errorf("expected the default SOCKS port, got %v, %v", u, err)
+	}
+	u, err = parseProxy("http://proxy.example.org")
+	if err != nil || u.Host != "proxy.example.org:8080" {
+		t.Errorf("expected the default HTTP port, got %v, %v", u, err)
+	}
+	if _, err = parseProxy("ftp://proxy.example.org"); err == nil {
+		t.Errorf("expected an unsupported scheme to fail")
+	}
+}
+
+// readFull reads n bytes from r, or fails the test.
+func readFull(t *testing.T, r io.Reader, n int) []byte {
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		t.Errorf("failed to read: %v", err)
+	}
+	return buf
+}
+
+func TestSOCKS5Connect(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		if greeting := readFull(t, server, 3); !bytes.Equal(greeting, []byte{5, 1, socks5AuthPassword}) {
+			t.Errorf("unexpected greeting %v", greeting)
+		}
+		server.Write([]byte{5, socks5AuthPassword})
+
+		auth := readFull(t, server, 2)
+		user := readFull(t, server, int(auth[1]))
+		password := readFull(t, server, int(readFull(t, server, 1)[0]))
+		if string(user) != "user" || string(password) != "secret" {
+			t.Errorf("unexpected credentials %q:%q", user, password)
+		}
+		server.Write([]byte{1, 0})
+
+		req := readFull(t, server, 5)
+		if !bytes.Equal(req[:4], []byte{5, socks5CmdConnect, 0, socks5AddrDomain}) {
+			t.Errorf("unexpected request %v", req)
+		}
+		host := readFull(t, server, int(req[4]))
+		port := readFull(t, server, 2)
+		if string(host) != "irc.example.onion" || port[0] != 6697>>8 || port[1] != 6697&0xFF {
+			t.Errorf("unexpected address %q:%v", host, port)
+		}
+		server.Write([]byte{5, 0, 0, socks5AddrDomain, 4, 'h', 'o', 's', 't', 0, 0})
+		server.Write([]byte("hello"))
+	}()
+
+	err := socks5Connect(client, url.UserPassword("user", "secret"), "irc.example.onion:6697")
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	if data := readFull(t, client, 5); string(data) != "hello" {
+		t.Errorf("expected the stream to start after the reply, got %q", data)
+	}
+}
+
+func TestSOCKS5ConnectError(t *testing.T) {
+	client, server := net.Pipe()
+	go func() {
+		readFull(t, server, 3)
+		server.Write([]byte{5, socks5AuthNone})
+		readFull(t, server, 4+net.IPv4len+2)
+		server.Write([]byte{5, 5, 0, socks5AddrIPv4})
+	}()
+	err := socks5Connect(client, nil, "192.0.2.1:6667")
+	if err == nil || err.Error() != "connection refused" {
+		t.Errorf("expected connection refused, got %v", err)
+	}
+	client.Close()
+	server.Close()
+
+	client, server = net.Pipe()
+	go func() {
+		readFull(t, server, 3)
+		server.Write([]byte{5, socks5AuthNoAccept})
+	}()
+	if err = socks5Connect(client, nil, "192.0.2.1:6667"); err == nil {
+		t.Errorf("expected no acceptable method to fail")
+	}
+	client.Close()
+	server.Close()
+}
+
+func TestHTTPConnect(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		req, err := http.ReadRequest(bufio.NewReader(server))
+		if err != nil {
+			t.Errorf("failed to read the request: %v", err)
+			return
+		}
+		if req.Method != "CONNECT" || req.Host != "irc.example.org:6697" {
+			t.Errorf("unexpected request %s %s", req.Method, req.Host)
+		}
+		auth := req.Header.Get("Proxy-Authorization")
+		if auth != "Basic "+base64.StdEncoding.EncodeToString([]byte("user:secret")) {
+			t.Errorf("unexpected credentials %q", auth)
+		}
+		server.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\nhello"))
+	}()
+
+	err := httpConnect(client, url.UserPassword("user", "secret"), "irc.example.org:6697")
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	if data := readFull(t, client, 5); string(data) != "hello" {
+		t.Errorf("expected the stream to start after the reply, got %q", data)
+	}
+}
+
+func TestHTTPConnectError(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		http.ReadRequest(bufio.NewReader(server))
+		server.Write([]byte("HTTP/1.1 403 Forbidden\r\nContent-Length: 0\r\n\r\n"))
+	}()
+	if err := httpConnect(client, nil, "irc.example.org:6697"); err == nil {
+		t.Errorf("expected a 403 reply to fail")
+	}
+}
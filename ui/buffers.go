@@ -36,6 +36,10 @@ type Line struct {
 	Highlight bool
 	Mergeable bool
 
+	// Event is the message the line shows, if any.  It must not be
+	// modified.
+	Event *irc.MessageEvent
+
 	splitPoints []point
 	width       int
 	newLines    []int
@@ -90,6 +94,24 @@ func (l *Line) NewLines(width int) []int {
 	l.newLines = l.newLines[:0]
 	l.width = width
 
+	if strings.IndexByte(l.Body.string, '\n') >= 0 {
+		// Multiline messages: lay out each line separately, and place a
+		// newline right after each '\n'.
+		offset := 0
+		for _, part := range strings.Split(l.Body.string, "\n") {
+			if offset != 0 {
+				l.newLines = append(l.newLines, offset)
+			}
+			pl := Line{Body: PlainString(part)}
+			pl.computeSplitPoints()
+			for _, nl := range pl.NewLines(width) {
+				l.newLines = append(l.newLines, offset+nl)
+			}
+			offset += len(part) + 1
+		}
+		return l.newLines
+	}
+
 	x := 0
 	for i := 1; i < len(l.splitPoints); i++ {
 		// Iterate through the split points 2 by 2.  Split points are placed at
@@ -174,12 +196,18 @@ func (l *Line) NewLines(width int) []int {
 }
 
 type buffer struct {
-	title      string
+	netID      string // the ID of the network the buffer belongs to, or "".
+	netName    string // the name of the network, shown for network buffers.
+	title      string // the name of the buffer, or "" for network buffers.
 	highlights int
 	unread     bool
+	inactive   bool // whether the channel is not joined.
 
 	lines []Line
 
+	read     time.Time // the read marker, known or sent to the server.
+	unreadAt time.Time // where the unread separator is drawn.
+
 	scrollAmt int
 	isAtTop   bool
 }
@@ -189,16 +217,20 @@ type BufferList struct {
 	current int
 	clicked int
 
+	markRead func(netID, title string, at time.Time)
+
 	tlInnerWidth int
 	tlHeight     int
 }
 
-// NewBufferList returns a new BufferList.
+// NewBufferList returns a new BufferList.  markRead, if not nil, is called
+// with the time of the last line of buffers when they are read.
 // Call Resize() once before using it.
-func NewBufferList() BufferList {
+func NewBufferList(markRead func(netID, title string, at time.Time)) BufferList {
 	return BufferList{
-		list:    []buffer{},
-		clicked: -1,
+		list:     []buffer{},
+		clicked:  -1,
+		markRead: markRead,
 	}
 }
 
@@ -212,58 +244,154 @@ func (bs *BufferList) To(i int) bool {
 		return false
 	}
 	if 0 <= i {
-		bs.current = i
-		if len(bs.list) <= bs.current {
-			bs.current = len(bs.list) - 1
+		if len(bs.list) <= i {
+			i = len(bs.list) - 1
 		}
-		bs.list[bs.current].highlights = 0
-		bs.list[bs.current].unread = false
+		bs.switchTo(i)
 		return true
 	}
 	return false
 }
 
 func (bs *BufferList) Next() {
-	bs.current = (bs.current + 1) % len(bs.list)
-	bs.list[bs.current].highlights = 0
-	bs.list[bs.current].unread = false
+	bs.switchTo((bs.current + 1) % len(bs.list))
 }
 
 func (bs *BufferList) Previous() {
-	bs.current = (bs.current - 1 + len(bs.list)) % len(bs.list)
-	bs.list[bs.current].highlights = 0
-	bs.list[bs.current].unread = false
+	bs.switchTo((bs.current - 1 + len(bs.list)) % len(bs.list))
 }
 
-func (bs *BufferList) Add(title string) (i int, added bool) {
-	lTitle := strings.ToLower(title)
-	for i, b := range bs.list {
-		if strings.ToLower(b.title) == lTitle {
-			return i, false
+// switchTo makes the i-th buffer the current one, and marks both the former
+// and the new current buffers as read.
+func (bs *BufferList) switchTo(i int) {
+	former := bs.current
+	bs.current = i
+	if former != i && former < len(bs.list) {
+		bs.readAll(former)
+		b := &bs.list[former]
+		b.unreadAt = b.read
+	}
+	bs.readAll(i)
+	bs.list[i].highlights = 0
+	bs.list[i].unread = false
+}
+
+// readAll moves the read marker of the i-th buffer to its last line.
+func (bs *BufferList) readAll(i int) {
+	b := &bs.list[i]
+	if len(b.lines) == 0 {
+		return
+	}
+	at := b.lines[len(b.lines)-1].At
+	if !at.After(b.read) {
+		return
+	}
+	b.read = at
+	if bs.markRead != nil {
+		bs.markRead(b.netID, b.title, at)
+	}
+}
+
+// SetRead updates the read marker of the given buffer, as received from the
+// server.  The buffer is no longer unread if the marker is past its last line.
+// The unread separator of the current buffer is not moved, so that the user
+// still sees where they left off.
+func (bs *BufferList) SetRead(netID, title string, at time.Time) {
+	idx := bs.idx(netID, title)
+	if idx < 0 {
+		return
+	}
+	b := &bs.list[idx]
+	at = at.UTC()
+	if !at.After(b.read) {
+		return
+	}
+	b.read = at
+	if idx == bs.current {
+		return
+	}
+	b.unreadAt = at
+	if n := len(b.lines); n == 0 || !b.lines[n-1].At.After(at) {
+		b.unread = false
+		b.highlights = 0
+	}
+}
+
+// Add adds a buffer to the list, after the other buffers of the same network.
+// The buffer of a network itself has an empty title.
+func (bs *BufferList) Add(netID, netName, title string) (i int, added bool) {
+	if i := bs.idx(netID, title); 0 <= i {
+		return i, false
+	}
+
+	i = len(bs.list)
+	for j, b := range bs.list {
+		if b.netID == netID {
+			i = j + 1
+		}
+	}
+	bs.list = append(bs.list, buffer{})
+	copy(bs.list[i+1:], bs.list[i:])
+	bs.list[i] = buffer{
+		netID:   netID,
+		netName: netName,
+		title:   title,
+	}
+	if i <= bs.current && 1 < len(bs.list) {
+		bs.current++
+	}
+	return i, true
+}
+
+func (bs *BufferList) Remove(netID, title string) (ok bool) {
+	i := bs.idx(netID, title)
+	if i < 0 {
+		return false
+	}
+	bs.remove(i)
+	return true
+}
+
+// RemoveNetwork removes all buffers of the given network.
+func (bs *BufferList) RemoveNetwork(netID string) {
+	for i := len(bs.list) - 1; 0 <= i; i-- {
+		if bs.list[i].netID == netID {
+			bs.remove(i)
 		}
 	}
+}
 
-	bs.list = append(bs.list, buffer{title: title})
-	return len(bs.list) - 1, true
+func (bs *BufferList) remove(i int) {
+	bs.list = append(bs.list[:i], bs.list[i+1:]...)
+	if i < bs.current || len(bs.list) <= bs.current {
+		bs.current--
+	}
+	if bs.current < 0 {
+		bs.current = 0
+	}
 }
 
-func (bs *BufferList) Remove(title string) (ok bool) {
-	lTitle := strings.ToLower(title)
-	for i, b := range bs.list {
-		if strings.ToLower(b.title) == lTitle {
-			ok = true
-			bs.list = append(bs.list[:i], bs.list[i+1:]...)
-			if len(bs.list) <= bs.current {
-				bs.current--
-			}
-			return
+// SetInactive sets whether the given buffer is shown as inactive, for example
+// when its channel is not joined.
+func (bs *BufferList) SetInactive(netID, title string, inactive bool) {
+	i := bs.idx(netID, title)
+	if i < 0 {
+		return
+	}
+	bs.list[i].inactive = inactive
+}
+
+// RenameNetwork changes the name shown for the given network.
+func (bs *BufferList) RenameNetwork(netID, netName string) {
+	for i := range bs.list {
+		if bs.list[i].netID == netID {
+			bs.list[i].netName = netName
 		}
 	}
-	return
 }
 
-func (bs *BufferList) AddLine(title string, notify NotifyType, line Line) {
-	idx := bs.idx(title)
+func (bs *BufferList) AddLine(netID, title string, notify NotifyType, line Line) {
+	idx := bs.idx(netID, title)
 	if idx < 0 {
 		return
 	}
@@ -299,8 +427,8 @@ func (bs *BufferList) AddLine(title string, notify NotifyType, line Line) {
 	}
 }
 
-func (bs *BufferList) AddLines(title string, before, after []Line) {
-	idx := bs.idx(title)
+func (bs *BufferList) AddLines(netID, title string, before, after []Line) {
+	idx := bs.idx(netID, title)
 	if idx < 0 {
 		return
 	}
@@ -322,8 +450,9 @@ func (bs *BufferList) AddLines(title string, before, after []Line) {
 	}
 }
 
-func (bs *BufferList) Current() (title string) {
-	return bs.list[bs.current].title
+func (bs *BufferList) Current() (netID, title string) {
+	b := &bs.list[bs.current]
+	return b.netID, b.title
 }
 
 func (bs *BufferList) ScrollUp(n int) {
@@ -348,20 +477,33 @@ func (bs *BufferList) IsAtTop() bool {
 	return b.isAtTop
 }
 
-func (bs *BufferList) idx(title string) int {
-	if title == "" {
-		return bs.current
-	}
-
+func (bs *BufferList) idx(netID, title string) int {
 	lTitle := strings.ToLower(title)
 	for i, b := range bs.list {
-		if strings.ToLower(b.title) == lTitle {
+		if b.netID == netID && strings.ToLower(b.title) == lTitle {
 			return i
 		}
 	}
 	return -1
 }
 
+// isUnreadSeparator reports whether the unread separator is drawn after the
+// i-th line.
+func (b *buffer) isUnreadSeparator(i int) bool {
+	if b.unreadAt.IsZero() || i+1 >= len(b.lines) {
+		return false
+	}
+	return !b.lines[i].At.After(b.unreadAt) && b.lines[i+1].At.After(b.unreadAt)
+}
+
+// displayTitle returns the name of the buffer shown in the buffer list.
+func (b *buffer) displayTitle() string {
+	if b.title == "" {
+		return b.netName
+	}
+	return b.title
+}
+
 func (bs *BufferList) DrawVerticalBufferList(screen tcell.Screen, x0, y0, width, height int) {
 	width--
 	st := tcell.StyleDefault
@@ -383,6 +525,9 @@ func (bs *BufferList) DrawVerticalBufferList(screen tcell.Screen, x0, y0, width,
 		} else if y == bs.current {
 			st = st.Underline(true)
 		}
+		if b.inactive {
+			st = st.Foreground(tcell.ColorGray)
+		}
 		if i == bs.clicked {
 			st = st.Reverse(true)
 		}
@@ -391,7 +536,13 @@ func (bs *BufferList) DrawVerticalBufferList(screen tcell.Screen, x0, y0, width,
 			screen.SetContent(x, y, ' ', nil, tcell.StyleDefault)
 		}
 		printString(screen, &x, y, Styled(indexText, st.Foreground(tcell.ColorGrey)))
-		title := truncate(b.title, width-(x-x0), "\u2026")
+		if b.netID != "" && b.title != "" {
+			// Indent the buffers of bouncer networks under the
+			// network buffer.
+			screen.SetContent(x, y, ' ', nil, tcell.StyleDefault)
+			x++
+		}
+		title := truncate(b.displayTitle(), width-(x-x0), "\u2026")
 		printString(screen, &x, y, Styled(title, st))
 		if 0 < b.highlights {
 			st = st.Foreground(tcell.ColorRed).Reverse(true)
@@ -424,10 +575,13 @@ func (bs *BufferList) DrawHorizontalBufferList(screen tcell.Screen, x0, y0, widt
 		} else if i == bs.current {
 			st = st.Underline(true)
 		}
+		if b.inactive {
+			st = st.Foreground(tcell.ColorGray)
+		}
 		if i == bs.clicked {
 			st = st.Reverse(true)
 		}
-		title := truncate(b.title, width-x, "\u2026")
+		title := truncate(b.displayTitle(), width-x, "\u2026")
 		printString(screen, &x, y0, Styled(title, st))
 		if 0 < b.highlights {
 			st = st.Foreground(tcell.ColorRed).Reverse(true)
@@ -473,6 +627,9 @@ func (bs *BufferList) DrawVerticalMemberList(screen tcell.Screen, x0, y0, width,
 		} else {
 			x += 1
 		}
+		if m.Away {
+			st = st.Foreground(tcell.ColorGray)
+		}
 		name := truncate(m.Name.Name, width-(x-x0), "\u2026")
 		printString(screen, &x, y, Styled(name, st))
 	}
@@ -495,6 +652,18 @@ func (bs *BufferList) DrawTimeline(screen tcell.Screen, x0, y0, nickColWidth int
 		x1 := x0 + 9 + nickColWidth
 
 		line := &b.lines[i]
+		if b.isUnreadSeparator(i) {
+			yi--
+			if y0 <= yi && yi < y0+bs.tlHeight {
+				st := tcell.StyleDefault.Foreground(tcell.ColorRed)
+				for x := x0; x < x1+bs.tlInnerWidth; x++ {
+					screen.SetContent(x, yi, 0x2500, nil, st)
+				}
+			}
+			if yi < 0 {
+				break
+			}
+		}
 		nls := line.NewLines(bs.tlInnerWidth)
 		yi -= len(nls) + 1
 		if y0+bs.tlHeight <= yi {
@@ -509,6 +678,9 @@ func (bs *BufferList) DrawTimeline(screen tcell.Screen, x0, y0, nickColWidth int
 		identSt := tcell.StyleDefault.
 			Foreground(line.HeadColor).
 			Reverse(line.Highlight)
+		if line.Event != nil && line.Event.Bot {
+			identSt = identSt.Italic(true)
+		}
 		printIdent(screen, x0+7, yi, nickColWidth, Styled(line.Head, identSt))
 
 		x := x1
@@ -530,7 +702,7 @@ func (bs *BufferList) DrawTimeline(screen tcell.Screen, x0, y0, nickColWidth int
 				}
 			}
 
-			if y != yi && x == x1 && IsSplitRune(r) {
+			if r == '\n' || (y != yi && x == x1 && IsSplitRune(r)) {
 				continue
 			}
 
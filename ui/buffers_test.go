@@ -3,6 +3,7 @@ package ui
 import (
 	"strings"
 	"testing"
+	"time"
 )
 
 func assertSplitPoints(t *testing.T, body string, expected []point) {
@@ -141,4 +142,55 @@ func TestRenderedHeight(t *testing.T) {
 	assertNewLines(t, "have a good day!", 17, 1) // |have a good day! |
 
 	assertNewLines(t, "cc en direct du word wrapping des familles le tests ça v a va va v a va", 46, 2)
+
+	// Multiline messages
+	assertNewLines(t, "take\ncare", 10, 2)   // |take|care|
+	assertNewLines(t, "take\n\ncare", 10, 3) // |take||care|
+	assertNewLines(t, "take care\nof", 4, 3) // |take|care|of|
+}
+
+func TestReadMarker(t *testing.T) {
+	var sent []time.Time
+	bs := NewBufferList(func(netID, title string, at time.Time) {
+		sent = append(sent, at)
+	})
+	bs.Add("", "", "home")
+	bs.Add("", "", "#senpai")
+
+	t0 := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		bs.AddLine("", "#senpai", NotifyHighlight, Line{
+			At:   t0.Add(time.Duration(i) * time.Minute),
+			Body: PlainString("hello"),
+		})
+	}
+
+	bs.SetRead("", "#senpai", t0.Add(time.Minute))
+	b := &bs.list[1]
+	if !b.unread || b.highlights != 3 {
+		t.Errorf("read marker before the last line: expected buffer to stay unread")
+	}
+	if !b.isUnreadSeparator(1) || b.isUnreadSeparator(0) {
+		t.Errorf("expected the unread separator after the second line")
+	}
+
+	bs.SetRead("", "#senpai", t0.Add(2*time.Minute))
+	if b.unread || b.highlights != 0 {
+		t.Errorf("read marker at the last line: expected buffer to be read")
+	}
+	if len(sent) != 0 {
+		t.Errorf("expected no read marker to be sent back, got %v", sent)
+	}
+
+	bs.AddLine("", "#senpai", NotifyUnread, Line{
+		At:   t0.Add(3 * time.Minute),
+		Body: PlainString("world"),
+	})
+	bs.Next()
+	if len(sent) != 1 || !sent[0].Equal(t0.Add(3*time.Minute)) {
+		t.Errorf("expected the time of the last line to be sent, got %v", sent)
+	}
+	if !b.isUnreadSeparator(2) {
+		t.Errorf("expected the unread separator to stay while viewing the buffer")
+	}
 }
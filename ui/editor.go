@@ -108,7 +108,7 @@ func (e *Editor) putRune(r rune) {
 	copy(e.text[e.lineIdx][e.cursorIdx+1:], e.text[e.lineIdx][e.cursorIdx:])
 	e.text[e.lineIdx][e.cursorIdx] = r
 
-	rw := runeWidth(r)
+	rw := runeWidth(displayRune(r))
 	tw := e.textWidth[len(e.textWidth)-1]
 	e.textWidth = append(e.textWidth, tw+rw)
 	for i := e.cursorIdx + 1; i < len(e.textWidth); i++ {
@@ -407,7 +407,7 @@ func (e *Editor) computeTextWidth() {
 	e.textWidth = e.textWidth[:1]
 	rw := 0
 	for _, r := range e.text[e.lineIdx] {
-		rw += runeWidth(r)
+		rw += runeWidth(displayRune(r))
 		e.textWidth = append(e.textWidth, rw)
 	}
 }
@@ -424,6 +424,10 @@ func (e *Editor) Draw(screen tcell.Screen, x0, y int) {
 		if e.backsearch && i < e.cursorIdx && i >= e.cursorIdx-len(e.backsearchPattern) {
 			s = s.Underline(true)
 		}
+		if r == '\n' {
+			s = s.Dim(true)
+		}
+		r = displayRune(r)
 		screen.SetContent(x, y, r, nil, s)
 		x += runeWidth(r)
 		i++
@@ -438,6 +442,15 @@ func (e *Editor) Draw(screen tcell.Screen, x0, y int) {
 	screen.ShowCursor(cursorX, y)
 }
 
+// displayRune returns the rune shown in place of r in the editor.  Newlines,
+// which can be pasted in multiline messages, are shown as a return symbol.
+func displayRune(r rune) rune {
+	if r == '\n' {
+		return '\u21B5'
+	}
+	return r
+}
+
 // runeOffset returns the lowercase version of a rune
 // TODO: len(strings.ToLower(string(r))) == len(strings.ToUpper(string(r))) for all x?
 func runeToLower(r rune) rune {
@@ -146,10 +146,9 @@ func IRCString(raw string) StyledString {
 	var last tcell.Style
 
 	for len(raw) != 0 {
+		// Invalid bytes are decoded as utf8.RuneError, and thus shown
+		// as replacement characters.
 		r, runeSize := utf8.DecodeRuneInString(raw)
-		if r == utf8.RuneError {
-			break
-		}
 		_, _, lastAttrs := last.Decompose()
 		current := last
 		if r == 0x0F {
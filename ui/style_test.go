@@ -93,4 +93,15 @@ func TestIRCString(t *testing.T) {
 			{Start: 0, Style: tcell.StyleDefault.Foreground(tcell.ColorBrown).Background(tcell.ColorWhite)},
 		},
 	})
+	assertIRCString(t, "caf\xe9 au lait", StyledString{
+		string: "caf\uFFFD au lait",
+		styles: nil,
+	})
+	assertIRCString(t, "\x02\xff\x02hello", StyledString{
+		string: "\uFFFDhello",
+		styles: []rangedStyle{
+			{Start: 0, Style: tcell.StyleDefault.Bold(true)},
+			{Start: 3, Style: tcell.StyleDefault},
+		},
+	})
 }
@@ -3,6 +3,7 @@ package ui
 import (
 	"strings"
 	"sync/atomic"
+	"time"
 
 	"git.sr.ht/~taiite/senpai/irc"
 
@@ -14,6 +15,7 @@ type Config struct {
 	ChanColWidth   int
 	MemberColWidth int
 	AutoComplete   func(cursorIdx int, text []rune) []Completion
+	MarkRead       func(netID, buffer string, at time.Time)
 	Mouse          bool
 }
 
@@ -63,7 +65,7 @@ func New(config Config) (ui *UI, err error) {
 		}
 	}()
 
-	ui.bs = NewBufferList()
+	ui.bs = NewBufferList(ui.config.MarkRead)
 	ui.e = NewEditor(ui.config.AutoComplete)
 	ui.Resize()
 
@@ -82,7 +84,7 @@ func (ui *UI) Close() {
 	ui.screen.Fini()
 }
 
-func (ui *UI) CurrentBuffer() string {
+func (ui *UI) CurrentBuffer() (netID, title string) {
 	return ui.bs.Current()
 }
 
@@ -143,27 +145,48 @@ func (ui *UI) IsAtTop() bool {
 	return ui.bs.IsAtTop()
 }
 
-func (ui *UI) AddBuffer(title string) (i int, added bool) {
-	return ui.bs.Add(title)
+func (ui *UI) AddBuffer(netID, netName, title string) (i int, added bool) {
+	return ui.bs.Add(netID, netName, title)
 }
 
-func (ui *UI) RemoveBuffer(title string) {
-	_ = ui.bs.Remove(title)
+func (ui *UI) HasBuffer(netID, title string) bool {
+	return 0 <= ui.bs.idx(netID, title)
+}
+
+func (ui *UI) RemoveBuffer(netID, title string) {
+	_ = ui.bs.Remove(netID, title)
+	ui.memberOffset = 0
+}
+
+func (ui *UI) RemoveNetworkBuffers(netID string) {
+	ui.bs.RemoveNetwork(netID)
 	ui.memberOffset = 0
 }
 
-func (ui *UI) AddLine(buffer string, notify NotifyType, line Line) {
-	ui.bs.AddLine(buffer, notify, line)
+func (ui *UI) SetInactive(netID, buffer string, inactive bool) {
+	ui.bs.SetInactive(netID, buffer, inactive)
+}
+
+func (ui *UI) RenameNetwork(netID, netName string) {
+	ui.bs.RenameNetwork(netID, netName)
+}
+
+func (ui *UI) AddLine(netID, buffer string, notify NotifyType, line Line) {
+	ui.bs.AddLine(netID, buffer, notify, line)
+}
+
+func (ui *UI) AddLines(netID, buffer string, before, after []Line) {
+	ui.bs.AddLines(netID, buffer, before, after)
 }
 
-func (ui *UI) AddLines(buffer string, before, after []Line) {
-	ui.bs.AddLines(buffer, before, after)
+func (ui *UI) SetRead(netID, buffer string, at time.Time) {
+	ui.bs.SetRead(netID, buffer, at)
 }
 
 func (ui *UI) JumpBuffer(sub string) bool {
 	subLower := strings.ToLower(sub)
 	for i, b := range ui.bs.list {
-		if strings.Contains(strings.ToLower(b.title), subLower) {
+		if strings.Contains(strings.ToLower(b.displayTitle()), subLower) {
 			if ui.bs.To(i) {
 				ui.memberOffset = 0
 			}
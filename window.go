@@ -11,54 +11,91 @@ import (
 
 var Home = "home"
 
+// serverBuffer is the title of the buffer where server notices are shown.
+const serverBuffer = "*server*"
+
 const welcomeMessage = "senpai dev build. See senpai(1) for a list of keybindings and commands. Private messages and status notices go here."
 
+// statusLine is a line to be printed in the home buffer of a network.
+type statusLine struct {
+	netID string
+	line  ui.Line
+}
+
+// homeBuffer returns the title of the home buffer of the given network.  The
+// main connection has the "home" buffer, while the home buffer of a bouncer
+// network has an empty title; the buffer list shows the network name instead.
+func homeBuffer(netID string) string {
+	if netID == "" {
+		return Home
+	}
+	return ""
+}
+
+// isHome reports whether buffer is the home buffer of a network, or its
+// server buffer, neither of which is a channel or a query.
+func isHome(buffer string) bool {
+	return buffer == Home || buffer == "" || buffer == serverBuffer
+}
+
 func (app *App) initWindow() {
-	app.win.AddBuffer(Home)
-	app.win.AddLine(Home, ui.NotifyNone, ui.Line{
+	app.win.AddBuffer("", "", Home)
+	app.win.AddLine("", Home, ui.NotifyNone, ui.Line{
 		Head: "--",
 		Body: ui.PlainString(welcomeMessage),
 		At:   time.Now(),
 	})
 }
 
-func (app *App) queueStatusLine(line ui.Line) {
+func (app *App) queueStatusLine(netID string, line ui.Line) {
 	if line.At.IsZero() {
 		line.At = time.Now()
 	}
 	app.events <- event{
 		src:     uiEvent,
-		content: line,
+		content: statusLine{netID: netID, line: line},
 	}
 }
 
-func (app *App) addStatusLine(line ui.Line) {
-	buffer := app.win.CurrentBuffer()
-	if buffer != Home {
-		app.win.AddLine(Home, ui.NotifyNone, line)
+func (app *App) addStatusLine(netID string, line ui.Line) {
+	home := homeBuffer(netID)
+	currentNetID, buffer := app.win.CurrentBuffer()
+	if currentNetID != netID || buffer != home {
+		app.win.AddLine(netID, home, ui.NotifyNone, line)
 	}
-	app.win.AddLine(buffer, ui.NotifyNone, line)
+	app.win.AddLine(currentNetID, buffer, ui.NotifyNone, line)
 }
 
 func (app *App) setStatus() {
-	if app.s == nil {
+	s := app.session()
+	if s == nil {
 		return
 	}
-	ts := app.s.Typings(app.win.CurrentBuffer())
-	status := ""
+	_, buffer := app.win.CurrentBuffer()
+	var statuses []string
+	if status := typingStatus(s.Typings(buffer), " is typing...", " are typing..."); status != "" {
+		statuses = append(statuses, status)
+	}
+	if status := typingStatus(s.PausedTypings(buffer), " stopped typing", " stopped typing"); status != "" {
+		statuses = append(statuses, status)
+	}
+	app.win.SetStatus(strings.Join(statuses, ", "))
+}
+
+// typingStatus returns a sentence such as "alice and bob are typing...".
+func typingStatus(ts []string, verb, verbPlural string) string {
 	if 3 < len(ts) {
-		status = "several people are typing..."
-	} else {
-		verb := " is typing..."
-		if 1 < len(ts) {
-			verb = " are typing..."
-			status = strings.Join(ts[:len(ts)-1], ", ") + " and "
-		}
-		if 0 < len(ts) {
-			status += ts[len(ts)-1] + verb
-		}
+		return "several people" + verbPlural
+	}
+	status := ""
+	if 1 < len(ts) {
+		verb = verbPlural
+		status = strings.Join(ts[:len(ts)-1], ", ") + " and "
+	}
+	if 0 < len(ts) {
+		status += ts[len(ts)-1] + verb
 	}
-	app.win.SetStatus(status)
+	return status
 }
 
 func identColor(ident string) tcell.Color {